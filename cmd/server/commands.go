@@ -0,0 +1,958 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/uuid"
+
+	"driving-hours/internal/auth"
+	"driving-hours/internal/backup"
+	"driving-hours/internal/config"
+	"driving-hours/internal/models"
+	"driving-hours/internal/notify"
+	"driving-hours/internal/storage"
+	"driving-hours/internal/utils"
+	"driving-hours/internal/webhooks"
+)
+
+// exportFile is the layout written by export and read by import
+type exportFile struct {
+	ExportedAt time.Time      `json:"exported_at"`
+	Admin      *models.User   `json:"admin,omitempty"`
+	Users      []*models.User `json:"users"`
+}
+
+func runAdmin(cfg *config.Config, args []string) error {
+	if len(args) == 0 || args[0] != "reset-password" {
+		return errors.New("usage: server admin reset-password [-password <password>]")
+	}
+
+	fs := flag.NewFlagSet("admin reset-password", flag.ExitOnError)
+	password := fs.String("password", "", "new password (generated when empty)")
+	fs.Parse(args[1:])
+
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	admin, err := store.GetAdmin()
+	if err != nil {
+		return err
+	}
+
+	// No admin yet: create one exactly as the first server start would
+	if admin == nil {
+		result, err := storage.Initialize(store, auth.HashPassword, auth.GenerateRandomPassword)
+		if err != nil {
+			return err
+		}
+		admin, err = store.GetAdmin()
+		if err != nil {
+			return err
+		}
+		if *password == "" {
+			fmt.Printf("Admin account created\n  Email:    %s\n  Password: %s\n", result.AdminEmail, result.AdminPassword)
+			return nil
+		}
+	}
+
+	newPassword := *password
+	if newPassword == "" {
+		newPassword, err = auth.GenerateRandomPassword(16)
+		if err != nil {
+			return err
+		}
+	} else if ok, msg := utils.ValidatePassword(newPassword); !ok {
+		return errors.New(msg)
+	}
+
+	hash, err := auth.HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+	admin.PasswordHash = hash
+
+	if err := store.SaveAdmin(admin); err != nil {
+		return err
+	}
+
+	fmt.Printf("Admin password reset\n  Email:    %s\n  Password: %s\n", admin.Email, newPassword)
+	return nil
+}
+
+func runUser(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: server user <create|list> [options]")
+	}
+
+	switch args[0] {
+	case "create":
+		return userCreate(cfg, args[1:])
+	case "list":
+		return userList(cfg, args[1:])
+	default:
+		return fmt.Errorf("unknown user command: %s", args[0])
+	}
+}
+
+func userCreate(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("user create", flag.ExitOnError)
+	email := fs.String("email", "", "email address (required)")
+	name := fs.String("name", "", "display name (required)")
+	password := fs.String("password", "", "password (generated when empty)")
+	role := fs.String("role", string(models.RoleDriver), "role: driver or admin")
+	dayHours := fs.Float64("day-hours", cfg.DefaultDayHours, "required day hours")
+	nightHours := fs.Float64("night-hours", cfg.DefaultNightHours, "required night hours")
+	group := fs.String("group", "", "group")
+	instructor := fs.String("instructor", "", "instructor")
+	timezone := fs.String("timezone", "", "IANA timezone such as America/Chicago (default: organisation timezone)")
+	locale := fs.String("locale", "", "interface language such as en or es (default: follow the browser)")
+	fs.Parse(args)
+
+	if !utils.ValidateEmail(*email) {
+		return errors.New("a valid -email is required")
+	}
+	if ok, msg := utils.ValidateName(*name); !ok {
+		return errors.New(msg)
+	}
+	if models.Role(*role) != models.RoleDriver && models.Role(*role) != models.RoleAdmin {
+		return fmt.Errorf("invalid role: %s", *role)
+	}
+	if ok, msg := utils.ValidateTimezone(*timezone); !ok {
+		return fmt.Errorf("%s: %s", msg, *timezone)
+	}
+	if ok, msg := utils.ValidateLocale(*locale); !ok {
+		return fmt.Errorf("%s: %s", msg, *locale)
+	}
+
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	existing, err := store.GetUserByEmail(*email)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return errors.New("email already in use")
+	}
+
+	newPassword := *password
+	if newPassword == "" {
+		newPassword, err = auth.GenerateRandomPassword(16)
+		if err != nil {
+			return err
+		}
+	} else if ok, msg := utils.ValidatePassword(newPassword); !ok {
+		return errors.New(msg)
+	}
+
+	hash, err := auth.HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	user := &models.User{
+		ID:                 uuid.New().String(),
+		Email:              strings.TrimSpace(*email),
+		Name:               strings.TrimSpace(*name),
+		PasswordHash:       hash,
+		Role:               models.Role(*role),
+		RequiredDayHours:   *dayHours,
+		RequiredNightHours: *nightHours,
+		Group:              *group,
+		Instructor:         *instructor,
+		Timezone:           *timezone,
+		Locale:             *locale,
+		CreatedAt:          now,
+		UpdatedAt:          now,
+	}
+
+	if err := store.SaveUser(user); err != nil {
+		return err
+	}
+
+	fmt.Printf("User created\n  ID:       %s\n  Email:    %s\n  Password: %s\n", user.ID, user.Email, newPassword)
+	return nil
+}
+
+func userList(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("user list", flag.ExitOnError)
+	archived := fs.Bool("archived", false, "include archived users")
+	role := fs.String("role", "", "only list users with this role")
+	fs.Parse(args)
+
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	users, err := store.GetAllUsers()
+	if err != nil {
+		return err
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return strings.ToLower(users[i].Name) < strings.ToLower(users[j].Name)
+	})
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tEMAIL\tROLE\tDAY\tNIGHT\tSTATUS")
+	for _, u := range users {
+		if u.IsArchived() && !*archived {
+			continue
+		}
+		if *role != "" && string(u.Role) != *role {
+			continue
+		}
+		status := "active"
+		if u.IsArchived() {
+			status = "archived"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%.2f/%.2f\t%.2f/%.2f\t%s\n",
+			u.ID, u.Name, u.Email, u.Role,
+			u.TotalDayHours(), u.RequiredDayHours,
+			u.TotalNightHours(), u.RequiredNightHours,
+			status)
+	}
+	return tw.Flush()
+}
+
+func runExport(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	output := fs.String("o", "", "output file (defaults to stdout)")
+	fs.Parse(args)
+
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	admin, err := store.GetAdmin()
+	if err != nil {
+		return err
+	}
+	users, err := store.GetAllUsers()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(exportFile{
+		ExportedAt: time.Now(),
+		Admin:      admin,
+		Users:      users,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.OpenFile(*output, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
+
+func runImport(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	overwrite := fs.Bool("overwrite", false, "replace users that already exist")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errors.New("usage: server import [-overwrite] <file>")
+	}
+
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var in exportFile
+	if err := json.Unmarshal(data, &in); err != nil {
+		return fmt.Errorf("invalid export file: %w", err)
+	}
+
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	// The admin is only imported into an install that has none
+	if in.Admin != nil {
+		admin, err := store.GetAdmin()
+		if err != nil {
+			return err
+		}
+		if admin == nil {
+			// Versions count saves in the install the export came from
+			in.Admin.Version = 0
+			if err := store.SaveAdmin(in.Admin); err != nil {
+				return err
+			}
+			fmt.Printf("imported admin %s\n", in.Admin.Email)
+		}
+	}
+
+	imported, skipped := 0, 0
+	for _, user := range in.Users {
+		if user.ID == "" || user.Email == "" {
+			fmt.Printf("skipped record without id or email\n")
+			skipped++
+			continue
+		}
+
+		existing, err := store.GetUser(user.ID)
+		if err != nil {
+			return err
+		}
+		if existing != nil && !*overwrite {
+			fmt.Printf("skipped %s: already exists\n", user.Email)
+			skipped++
+			continue
+		}
+
+		byEmail, err := store.GetUserByEmail(user.Email)
+		if err != nil {
+			return err
+		}
+		if byEmail != nil && byEmail.ID != user.ID {
+			fmt.Printf("skipped %s: email belongs to another user\n", user.Email)
+			skipped++
+			continue
+		}
+
+		user.Version = 0
+		if existing != nil {
+			user.Version = existing.Version
+		}
+		if err := store.SaveUser(user); err != nil {
+			return fmt.Errorf("failed to import %s: %w", user.Email, err)
+		}
+		// SaveUser leaves an existing user's log alone, so replace it entry by entry
+		if existing != nil {
+			if err := replaceLog(store, user.ID, user.DrivingLog); err != nil {
+				return fmt.Errorf("failed to import hours of %s: %w", user.Email, err)
+			}
+		}
+		imported++
+	}
+
+	fmt.Printf("Imported %d users, skipped %d\n", imported, skipped)
+	return nil
+}
+
+// replaceLog makes a user's stored log match log
+func replaceLog(store storage.Storage, userID string, log models.DrivingLog) error {
+	current, err := store.ListLogEntries(userID, "", "")
+	if err != nil {
+		return err
+	}
+	for date := range current {
+		if _, ok := log[date]; !ok {
+			if err := store.DeleteLogEntry(userID, date); err != nil {
+				return err
+			}
+		}
+	}
+	for date, entry := range log {
+		if current[date] != entry {
+			if err := store.PutLogEntry(userID, date, entry); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func runBackup(cfg *config.Config, args []string) error {
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	backups, err := backup.NewManager(store, cfg.DataDir, cfg.BackupDir, cfg.BackupKeep)
+	if err != nil {
+		return err
+	}
+
+	info, err := backups.Create()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Created %s (%d bytes) in %s\n", info.Name, info.Size, cfg.BackupDir)
+	return nil
+}
+
+func runRestore(cfg *config.Config, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: server restore <archive>")
+	}
+
+	previous, err := backup.Restore(args[0], cfg.DataDir, cfg.BackupDir)
+	if errors.Is(err, storage.ErrLocked) {
+		return fmt.Errorf("restore failed: %w; stop the server first", err)
+	} else if err != nil {
+		return fmt.Errorf("restore failed: %w", err)
+	}
+
+	fmt.Printf("Restored %s into %s\n", args[0], cfg.DataDir)
+	fmt.Printf("Previous data was moved to %s\n", previous)
+	return nil
+}
+
+func runMigrate(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "list what would change without writing anything")
+	noBackup := fs.Bool("no-backup", false, "don't take a backup first")
+	fs.Parse(args)
+
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	admin, err := store.GetAdmin()
+	if err != nil {
+		return err
+	}
+	users, err := store.GetAllUsers()
+	if err != nil {
+		return err
+	}
+
+	// Emails used to be compared exactly, so some may differ only in case.
+	// Saving normalizes them, which fails for all but one of each group.
+	accounts := users
+	if admin != nil {
+		accounts = append([]*models.User{admin}, users...)
+	}
+	duplicates := storage.FindDuplicateEmails(accounts)
+	for _, d := range duplicates {
+		fmt.Printf("duplicate email %s:\n", d.Email)
+		for _, u := range d.Users {
+			fmt.Printf("  %s  %s  %s  created %s\n", u.ID, u.Email, u.Name, u.CreatedAt.Format(time.DateOnly))
+		}
+	}
+	var unnormalized []*models.User
+	for _, u := range accounts {
+		if u.Email != models.NormalizeEmail(u.Email) {
+			unnormalized = append(unnormalized, u)
+		}
+	}
+
+	plan, err := store.Migrate(true)
+	if err != nil {
+		return err
+	}
+	oldest := plan.SchemaVersion
+	for _, r := range plan.Upgraded {
+		oldest = min(oldest, r.From)
+	}
+	fmt.Printf("Schema version %d; %d of %d records are older\n", plan.SchemaVersion, len(plan.Upgraded), plan.Files)
+	for _, m := range storage.PendingMigrations(oldest) {
+		fmt.Printf("  migration %s\n", m)
+	}
+
+	if *dryRun {
+		for _, r := range plan.Upgraded {
+			fmt.Printf("  %s: version %d\n", r.File, r.From)
+		}
+		for _, f := range plan.Restructured {
+			fmt.Printf("  %s: restructured\n", f)
+		}
+		for _, u := range unnormalized {
+			fmt.Printf("  %s: email %s becomes %s\n", u.ID, u.Email, models.NormalizeEmail(u.Email))
+		}
+		if len(duplicates) > 0 {
+			return fmt.Errorf("%d emails are shared by several users; change their emails before migrating", len(duplicates))
+		}
+		return nil
+	}
+	if len(plan.Upgraded) == 0 && len(plan.Restructured) == 0 && len(unnormalized) == 0 && len(duplicates) == 0 {
+		fmt.Println("Nothing to migrate")
+		return nil
+	}
+
+	if !*noBackup {
+		backups, err := backup.NewManager(store, cfg.DataDir, cfg.BackupDir, cfg.BackupKeep)
+		if err != nil {
+			return err
+		}
+		info, err := backups.Create()
+		if err != nil {
+			return fmt.Errorf("failed to back up before migrating: %w", err)
+		}
+		fmt.Printf("Backed up to %s\n", info.Name)
+	}
+
+	report, err := store.Migrate(false)
+	if report != nil {
+		fmt.Printf("Upgraded %d records and restructured %d files\n", len(report.Upgraded), len(report.Restructured))
+	}
+	if err != nil {
+		return err
+	}
+
+	count, skipped := 0, 0
+	for _, u := range unnormalized {
+		if admin != nil && u.ID == admin.ID {
+			err = store.SaveAdmin(u)
+		} else {
+			err = store.SaveUser(u)
+		}
+		if errors.Is(err, storage.ErrEmailTaken) {
+			skipped++
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to migrate %s: %w", u.ID, err)
+		}
+		count++
+	}
+	if len(unnormalized) > 0 {
+		fmt.Printf("Normalized %d emails\n", count)
+	}
+
+	if len(duplicates) > 0 {
+		return fmt.Errorf("%d emails are shared by several users and %d users were not rewritten; change their emails and run migrate again", len(duplicates), skipped)
+	}
+	return nil
+}
+
+func runCheck(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	repair := fs.Bool("repair", false, "fix the problems that can be fixed without losing data")
+	fs.Parse(args)
+
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	report, err := store.CheckIntegrity(*repair)
+	if report != nil && len(report.Problems) > 0 {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "PROBLEM\tFILE\tDETAIL\tREPAIR")
+		for _, p := range report.Problems {
+			repair := p.Repair
+			switch {
+			case repair == "":
+				repair = "-"
+			case p.Repaired:
+				repair = "done: " + repair
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", p.Kind, p.File, p.Detail, repair)
+		}
+		tw.Flush()
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Checked %d files: %d problems, %d repaired\n", report.Files, len(report.Problems), report.Repaired())
+	if remaining := len(report.Problems) - report.Repaired(); remaining > 0 {
+		if n := report.Repairable(); n > 0 {
+			fmt.Printf("Run \"server check -repair\" to fix %d of them\n", n)
+		}
+		return fmt.Errorf("%d problems remain", remaining)
+	}
+	return nil
+}
+
+func runSessions(cfg *config.Config, args []string) error {
+	if len(args) == 0 || args[0] != "prune" {
+		return errors.New("usage: server sessions prune")
+	}
+
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	if err := store.CleanExpiredSessions(); err != nil {
+		return err
+	}
+
+	fmt.Println("Expired sessions removed")
+	return nil
+}
+
+func runEncryption(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: server encryption genkey | status | rotate [-new-key-file <file> | -decrypt] [-old-key-file <file>]")
+	}
+
+	switch args[0] {
+	case "genkey":
+		key, err := storage.GenerateKey()
+		if err != nil {
+			return err
+		}
+		fmt.Println(key)
+		return nil
+	case "status":
+		return encryptionStatus(cfg)
+	case "rotate":
+		return encryptionRotate(cfg, args[1:])
+	default:
+		return fmt.Errorf("unknown encryption command: %s", args[0])
+	}
+}
+
+func encryptionStatus(cfg *config.Config) error {
+	key, err := encryptionKey(cfg)
+	if err != nil {
+		return err
+	}
+	store, err := openJSONStorage(cfg, key)
+	if err != nil {
+		return err
+	}
+
+	counts, err := store.EncryptionStatus()
+	if err != nil {
+		return err
+	}
+
+	if key == nil {
+		fmt.Println("Encryption is off")
+	} else {
+		fmt.Printf("Encrypting with key %s\n", storage.KeyID(key))
+	}
+	ids := make([]string, 0, len(counts))
+	for id := range counts {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if id == "" {
+			fmt.Printf("  plain text      %d records\n", counts[id])
+		} else {
+			fmt.Printf("  key %s    %d records\n", id, counts[id])
+		}
+	}
+	return nil
+}
+
+// encryptionRotate rewrites every record with a new key, or with the
+// configured key to encrypt records still in plain text. It should run while
+// the server is stopped; the server must then be configured with the new key.
+func encryptionRotate(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("encryption rotate", flag.ExitOnError)
+	newKeyFile := fs.String("new-key-file", "", "file holding the key to encrypt with (default: the configured key)")
+	oldKeyFile := fs.String("old-key-file", "", "file holding another key records may be encrypted with, e.g. to finish an interrupted rotation")
+	decrypt := fs.Bool("decrypt", false, "store every record in plain text")
+	fs.Parse(args)
+
+	current, err := encryptionKey(cfg)
+	if err != nil {
+		return err
+	}
+	var previous [][]byte
+	if current != nil {
+		previous = append(previous, current)
+	}
+	if *oldKeyFile != "" {
+		old, err := storage.ReadKeyFile(*oldKeyFile)
+		if err != nil {
+			return err
+		}
+		previous = append(previous, old)
+	}
+
+	next := current
+	switch {
+	case *decrypt && *newKeyFile != "":
+		return errors.New("-decrypt and -new-key-file can't be used together")
+	case *decrypt:
+		next = nil
+	case *newKeyFile != "":
+		if next, err = storage.ReadKeyFile(*newKeyFile); err != nil {
+			return err
+		}
+	case current == nil:
+		return errors.New("no encryption key is configured; set encryption_key or encryption_key_file, or pass -new-key-file")
+	}
+
+	store, err := openJSONStorage(cfg, next, previous...)
+	if err != nil {
+		return err
+	}
+	count, err := store.Reencrypt()
+	if err != nil {
+		return fmt.Errorf("stopped after rewriting %d records: %w", count, err)
+	}
+
+	if next == nil {
+		fmt.Printf("Decrypted %d records. Remove the encryption key from the configuration before starting the server.\n", count)
+	} else {
+		fmt.Printf("Encrypted %d records with key %s.\n", count, storage.KeyID(next))
+		if *newKeyFile != "" {
+			fmt.Println("Configure the server with the new key before starting it.")
+		}
+	}
+	return nil
+}
+
+func runNotify(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: server notify test -to <email> | outbox | retry [id]")
+	}
+
+	switch args[0] {
+	case "test":
+		return notifyTest(cfg, args[1:])
+	case "outbox":
+		return notifyOutbox(cfg)
+	case "retry":
+		return notifyRetry(cfg, args[1:])
+	default:
+		return fmt.Errorf("unknown notify command: %s", args[0])
+	}
+}
+
+func notifyTest(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("notify test", flag.ExitOnError)
+	to := fs.String("to", "", "recipient address (required)")
+	locale := fs.String("locale", "", "language to write the email in (defaults to default_locale)")
+	fs.Parse(args)
+
+	if *to == "" {
+		return errors.New("-to is required")
+	}
+	if ok, msg := utils.ValidateLocale(*locale); !ok {
+		return fmt.Errorf("%s: %s", msg, *locale)
+	}
+
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	notifier, err := newNotifier(cfg, store)
+	if err != nil {
+		return err
+	}
+
+	recipient := notify.Recipient{Email: *to, Locale: *locale}
+	if err := notifier.SendNow(context.Background(), recipient, "test", nil); err != nil {
+		return fmt.Errorf("send failed, the email stays in the outbox for retry: %w", err)
+	}
+
+	if cfg.MailTransport == "file" {
+		fmt.Printf("Test email written to %s\n", cfg.MailDir)
+	} else {
+		fmt.Printf("Test email sent to %s\n", *to)
+	}
+	return nil
+}
+
+func notifyOutbox(cfg *config.Config) error {
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	messages, err := store.GetOutboxMessages()
+	if err != nil {
+		return err
+	}
+	if len(messages) == 0 {
+		fmt.Println("Outbox is empty")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTEMPLATE\tTO\tCREATED\tATTEMPTS\tSTATUS\tLAST ERROR")
+	for _, msg := range messages {
+		status := "next " + msg.NextAttemptAt.Local().Format("2006-01-02 15:04")
+		if msg.Abandoned {
+			status = "abandoned"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			msg.ID, msg.Template, msg.To,
+			msg.CreatedAt.Local().Format("2006-01-02 15:04"),
+			msg.Attempts, status, msg.LastError)
+	}
+	return tw.Flush()
+}
+
+func notifyRetry(cfg *config.Config, args []string) error {
+	if len(args) > 1 {
+		return errors.New("usage: server notify retry [id]")
+	}
+	id := ""
+	if len(args) == 1 {
+		id = args[0]
+	}
+
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	notifier, err := newNotifier(cfg, store)
+	if err != nil {
+		return err
+	}
+
+	count, err := notifier.Retry(id)
+	if err != nil {
+		return err
+	}
+	if id != "" && count == 0 {
+		return fmt.Errorf("no email with ID %s in the outbox", id)
+	}
+
+	// Send now rather than waiting for the running server's next flush
+	if err := notifier.Flush(context.Background()); err != nil {
+		return err
+	}
+	fmt.Printf("Sent %d emails\n", count)
+	return nil
+}
+
+func runWebhooks(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: server webhooks list | receive [-addr host:port] [-secret <secret>] [-status <code>]")
+	}
+
+	switch args[0] {
+	case "list":
+		return webhooksList(cfg)
+	case "receive":
+		return webhooksReceive(args[1:])
+	default:
+		return fmt.Errorf("unknown webhooks command: %s", args[0])
+	}
+}
+
+func webhooksList(cfg *config.Config) error {
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	hooks, err := store.GetWebhooks()
+	if err != nil {
+		return err
+	}
+	if len(hooks) == 0 {
+		fmt.Println("No webhooks")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tURL\tEVENTS\tACTIVE\tPENDING")
+	for _, hook := range hooks {
+		deliveries, err := store.GetWebhookDeliveries(hook.ID)
+		if err != nil {
+			return err
+		}
+		pending := 0
+		for _, d := range deliveries {
+			if d.IsPending() {
+				pending++
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%t\t%d\n",
+			hook.ID, hook.URL, strings.Join(hook.Events, ","), hook.Active, pending)
+	}
+	return tw.Flush()
+}
+
+// webhooksReceive runs a local endpoint that prints each delivery it is sent,
+// for trying webhooks out without a real receiver
+func webhooksReceive(args []string) error {
+	fs := flag.NewFlagSet("webhooks receive", flag.ExitOnError)
+	addr := fs.String("addr", "127.0.0.1:9000", "address to listen on")
+	secret := fs.String("secret", "", "webhook secret to verify signatures with (empty skips verification)")
+	status := fs.Int("status", http.StatusOK, "status code to answer with, e.g. 500 to exercise retries")
+	fs.Parse(args)
+
+	if *status < 100 || *status > 599 {
+		return fmt.Errorf("invalid status code %d", *status)
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		signature := "not checked"
+		if *secret != "" {
+			signature = "valid"
+			err := webhooks.Verify(*secret, r.Header.Get(webhooks.HeaderTimestamp), r.Header.Get(webhooks.HeaderSignature), body, time.Now())
+			if err != nil {
+				signature = "INVALID: " + err.Error()
+			}
+		}
+
+		fmt.Printf("%s %s %s\n", time.Now().Format("15:04:05"), r.Method, r.URL.Path)
+		fmt.Printf("  event:     %s\n", r.Header.Get(webhooks.HeaderEvent))
+		fmt.Printf("  delivery:  %s\n", r.Header.Get(webhooks.HeaderDelivery))
+		fmt.Printf("  signature: %s\n", signature)
+
+		var pretty bytes.Buffer
+		if json.Indent(&pretty, body, "  ", "  ") == nil {
+			body = pretty.Bytes()
+		}
+		fmt.Printf("  %s\n\n", body)
+
+		if *secret != "" && signature != "valid" {
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(*status)
+		fmt.Fprintln(w, http.StatusText(*status))
+	})
+
+	fmt.Printf("Listening for webhooks on http://%s/ (Ctrl+C to stop)\n", *addr)
+	server := &http.Server{Addr: *addr, Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	return server.ListenAndServe()
+}
+
+func runConfig(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: server config print [-defaults] | check")
+	}
+
+	switch args[0] {
+	case "print":
+		fs := flag.NewFlagSet("config print", flag.ExitOnError)
+		defaults := fs.Bool("defaults", false, "print the built-in defaults instead of the effective configuration")
+		fs.Parse(args[1:])
+
+		if *defaults {
+			return config.Default().Print(os.Stdout)
+		}
+		return cfg.Print(os.Stdout)
+	case "check":
+		// Loading already validated everything
+		fmt.Println("Configuration is valid")
+		return nil
+	default:
+		return fmt.Errorf("unknown config command: %s", args[0])
+	}
+}
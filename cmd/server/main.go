@@ -2,135 +2,213 @@ package main
 
 import (
 	"fmt"
-	"log"
-	"net/http"
-	"path/filepath"
-
-	"github.com/go-chi/chi/v5"
-	chimiddleware "github.com/go-chi/chi/v5/middleware"
+	"os"
+	"strings"
+	"time"
+	_ "time/tzdata" // timezone names work even where the OS has no zoneinfo
 
 	"driving-hours/internal/auth"
 	"driving-hours/internal/config"
-	"driving-hours/internal/handlers"
-	"driving-hours/internal/middleware"
+	"driving-hours/internal/i18n"
+	"driving-hours/internal/logging"
+	"driving-hours/internal/models"
+	"driving-hours/internal/notify"
 	"driving-hours/internal/storage"
-	"driving-hours/internal/templates"
+	"driving-hours/internal/webhooks"
 )
 
+const usage = `Usage: server [-config <file>] [command] [arguments]
+
+Commands:
+  serve                     Start the web server (default)
+  admin reset-password      Set a new password for the admin account
+  user create               Create a user
+  user list                 List users
+  export                    Write all users as JSON
+  import <file>             Load users from a JSON export
+  backup                    Create a backup archive
+  restore <archive>         Restore the data directory from a backup archive
+  migrate                   Upgrade every record to the current schema version
+  check                     Validate every record; -repair fixes what is safe to fix
+  sessions prune            Remove expired sessions
+  encryption genkey         Print a new random encryption key
+  encryption status         Count records by the key they are encrypted with
+  encryption rotate         Re-encrypt every record with a new key
+  notify test -to <email>   Send a test email
+  notify outbox             List emails waiting to be delivered
+  notify retry [id]         Retry abandoned or waiting emails now
+  webhooks list             List webhooks and their pending deliveries
+  webhooks receive          Run a local endpoint that prints and verifies deliveries
+  config print              Show the effective configuration (secrets redacted)
+  config check              Validate the configuration and exit
+
+The configuration file can also be given with CONFIG_FILE. Environment
+variables override values from the file.
+
+Run "server <command> -h" for command options.
+`
+
+// command runs a subcommand with the remaining arguments
+type command func(cfg *config.Config, args []string) error
+
+var commands = map[string]command{
+	"serve":      serve,
+	"admin":      runAdmin,
+	"user":       runUser,
+	"export":     runExport,
+	"import":     runImport,
+	"backup":     runBackup,
+	"restore":    runRestore,
+	"migrate":    runMigrate,
+	"check":      runCheck,
+	"sessions":   runSessions,
+	"encryption": runEncryption,
+	"notify":     runNotify,
+	"webhooks":   runWebhooks,
+	"config":     runConfig,
+}
+
 func main() {
-	// Load configuration
-	cfg, err := config.Load()
+	configFile, args, err := splitConfigFlag(os.Args[1:])
 	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+		fmt.Fprintf(os.Stderr, "%v\n\n%s", err, usage)
+		os.Exit(2)
 	}
 
-	// Initialize storage
-	store, err := storage.NewJSONStorage(cfg.DataDir)
-	if err != nil {
-		log.Fatalf("Failed to initialize storage: %v", err)
+	name := "serve"
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
 	}
 
-	// Initialize admin on first run
-	initResult, err := storage.Initialize(store, auth.HashPassword, auth.GenerateRandomPassword)
+	if name == "help" || name == "-h" || name == "--help" {
+		fmt.Print(usage)
+		return
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n%s", name, usage)
+		os.Exit(2)
+	}
+
+	// Load configuration
+	cfg, err := config.Load(configFile)
 	if err != nil {
-		log.Fatalf("Failed to initialize admin: %v", err)
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		os.Exit(1)
 	}
 
-	if initResult.AdminCreated {
-		fmt.Println("\n========================================")
-		fmt.Println("  FIRST RUN - Admin Account Created")
-		fmt.Println("========================================")
-		fmt.Printf("  Email:    %s\n", initResult.AdminEmail)
-		fmt.Printf("  Password: %s\n", initResult.AdminPassword)
-		fmt.Println("========================================")
-		fmt.Println("  Please save these credentials!")
-		fmt.Println("========================================")
+	if _, err := logging.Setup(os.Stderr, cfg.LogFormat, cfg.LogLevel); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to configure logging: %v\n", err)
+		os.Exit(1)
 	}
 
-	// Clean expired sessions
-	if err := store.CleanExpiredSessions(); err != nil {
-		log.Printf("Warning: Failed to clean expired sessions: %v", err)
+	auth.SetArgon2Params(auth.Argon2Params{
+		Memory:      cfg.Argon2Memory,
+		Iterations:  cfg.Argon2Iterations,
+		Parallelism: cfg.Argon2Parallelism,
+	})
+
+	// Validated when the configuration was loaded
+	location, _ := time.LoadLocation(cfg.Timezone)
+	models.SetDefaultLocation(location)
+	if err := i18n.SetDefault(cfg.DefaultLocale); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: default_locale: %v\n", err)
+		os.Exit(1)
 	}
 
-	// Initialize template renderer
-	templatesDir := filepath.Join("web", "templates")
-	renderer, err := templates.NewRenderer(templatesDir)
-	if err != nil {
-		log.Fatalf("Failed to initialize templates: %v", err)
+	if err := cmd(cfg, args); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
 	}
+}
 
-	// Initialize session manager
-	sessions := auth.NewSessionManager(store, cfg.IsProd)
-
-	// Initialize handlers
-	authHandler := handlers.NewAuthHandler(store, sessions, renderer)
-	adminHandler := handlers.NewAdminHandler(store, sessions, renderer)
-	driverHandler := handlers.NewDriverHandler(store, renderer)
-
-	// Set up router
-	r := chi.NewRouter()
-
-	// Global middleware
-	r.Use(chimiddleware.Logger)
-	r.Use(chimiddleware.Recoverer)
-	r.Use(chimiddleware.RealIP)
-	r.Use(middleware.CSRFProtect(cfg.CSRFKey, cfg.IsProd))
-
-	// Static files
-	staticDir := filepath.Join("web", "static")
-	fs := http.FileServer(http.Dir(staticDir))
-	r.Handle("/static/*", http.StripPrefix("/static/", fs))
-
-	// Public routes
-	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
-		user, _ := sessions.GetUserFromSession(r)
-		if user == nil {
-			http.Redirect(w, r, "/login", http.StatusSeeOther)
-			return
-		}
-		if user.IsAdmin() {
-			http.Redirect(w, r, "/admin", http.StatusSeeOther)
-		} else {
-			http.Redirect(w, r, "/driver", http.StatusSeeOther)
+// splitConfigFlag removes a leading -config flag, which applies to every command
+func splitConfigFlag(args []string) (string, []string, error) {
+	if len(args) == 0 {
+		return "", args, nil
+	}
+
+	switch arg := args[0]; {
+	case arg == "-config" || arg == "--config":
+		if len(args) < 2 {
+			return "", nil, fmt.Errorf("%s requires a file", arg)
 		}
-	})
+		return args[1], args[2:], nil
+	case strings.HasPrefix(arg, "-config="), strings.HasPrefix(arg, "--config="):
+		return arg[strings.IndexByte(arg, '=')+1:], args[1:], nil
+	}
+	return "", args, nil
+}
 
-	r.Get("/login", authHandler.LoginPage)
-	r.Post("/login", authHandler.Login)
-	r.Post("/logout", authHandler.Logout)
-
-	// Driver routes
-	r.Route("/driver", func(r chi.Router) {
-		r.Use(auth.RequireDriver(sessions))
-		r.Get("/", driverHandler.Dashboard)
-		r.Post("/log", driverHandler.LogHours)
-		r.Get("/profile", driverHandler.Profile)
-		r.Post("/profile", driverHandler.UpdateProfile)
-	})
+// openStore opens the storage backend described by the configuration
+func openStore(cfg *config.Config) (storage.Storage, error) {
+	key, err := encryptionKey(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return openJSONStorage(cfg, key)
+}
 
-	// Admin routes
-	r.Route("/admin", func(r chi.Router) {
-		r.Use(auth.RequireAdmin(sessions))
-		r.Get("/", adminHandler.Dashboard)
-		r.Get("/users", adminHandler.ListUsers)
-		r.Get("/users/new", adminHandler.NewUserForm)
-		r.Post("/users", adminHandler.CreateUser)
-		r.Get("/users/{id}", adminHandler.ViewDriver)
-		r.Get("/users/{id}/edit", adminHandler.EditUserForm)
-		r.Post("/users/{id}", adminHandler.UpdateUser)
-		r.Post("/users/{id}/delete", adminHandler.DeleteUser)
-		r.Get("/users/{id}/hours", adminHandler.EditHoursForm)
-		r.Post("/users/{id}/hours", adminHandler.UpdateHours)
-		r.Get("/users/{id}/export.csv", adminHandler.ExportDriverCSV)
-		r.Get("/profile", adminHandler.Profile)
-		r.Post("/profile", adminHandler.UpdateProfile)
-	})
+// openJSONStorage opens the data directory, encrypting with key if it isn't
+// nil. previous keys can still be read.
+func openJSONStorage(cfg *config.Config, key []byte, previous ...[]byte) (*storage.JSONStorage, error) {
+	cipher, err := storage.NewCipher(key, previous...)
+	if err != nil {
+		return nil, err
+	}
+	store, err := storage.NewJSONStorage(cfg.DataDir, cipher)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize storage: %w", err)
+	}
+	return store, nil
+}
 
-	// Start server
-	addr := fmt.Sprintf(":%d", cfg.Port)
-	log.Printf("Server starting on http://localhost%s", addr)
+// encryptionKey returns the configured encryption key, or nil if data is
+// stored in plain text
+func encryptionKey(cfg *config.Config) ([]byte, error) {
+	switch {
+	case cfg.EncryptionKey != "":
+		return storage.ParseKey(cfg.EncryptionKey)
+	case cfg.EncryptionKeyFile != "":
+		return storage.ReadKeyFile(cfg.EncryptionKeyFile)
+	}
+	return nil, nil
+}
 
-	if err := http.ListenAndServe(addr, r); err != nil {
-		log.Fatalf("Server failed: %v", err)
+// newNotifier builds the email notifier and its transport from the configuration
+func newNotifier(cfg *config.Config, store storage.Storage) (*notify.Notifier, error) {
+	var transport notify.Transport
+	switch cfg.MailTransport {
+	case "smtp":
+		transport = notify.NewSMTPTransport(notify.SMTPOptions{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			TLS:      cfg.SMTPTLS,
+		})
+	default:
+		files, err := notify.NewFileTransport(cfg.MailDir)
+		if err != nil {
+			return nil, err
+		}
+		transport = files
 	}
+
+	return notify.New(store, transport, notify.Options{
+		From:        cfg.MailFrom,
+		BaseURL:     cfg.BaseURL,
+		MaxAttempts: cfg.MailMaxAttempts,
+		RetryDelay:  cfg.MailRetryDelay,
+	})
+}
+
+// newDispatcher builds the webhook dispatcher from the configuration
+func newDispatcher(cfg *config.Config, store storage.Storage) *webhooks.Dispatcher {
+	return webhooks.New(store, webhooks.Options{
+		MaxAttempts: cfg.WebhookMaxAttempts,
+		RetryDelay:  cfg.WebhookRetryDelay,
+		Timeout:     cfg.WebhookTimeout,
+	})
 }
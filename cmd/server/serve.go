@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+
+	"driving-hours/internal/achievements"
+	"driving-hours/internal/assets"
+	"driving-hours/internal/auth"
+	"driving-hours/internal/backup"
+	"driving-hours/internal/config"
+	"driving-hours/internal/handlers"
+	"driving-hours/internal/i18n"
+	"driving-hours/internal/jobs"
+	"driving-hours/internal/logging"
+	"driving-hours/internal/metrics"
+	"driving-hours/internal/middleware"
+	"driving-hours/internal/reminders"
+	"driving-hours/internal/storage"
+	"driving-hours/internal/templates"
+	"driving-hours/internal/tlscert"
+	"driving-hours/web"
+)
+
+// serve runs the web server
+func serve(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	port := flags.Int("port", cfg.Port, "port to listen on")
+	flags.Parse(args)
+	cfg.Port = *port
+
+	// Held while serving so a restore can't swap the data out from under us
+	lock, err := storage.LockDataDir(cfg.DataDir)
+	if errors.Is(err, storage.ErrLocked) {
+		return fmt.Errorf("%w: is another server or a restore running on %s?", err, cfg.DataDir)
+	} else if err != nil {
+		return fmt.Errorf("failed to lock data directory: %w", err)
+	}
+	defer lock.Unlock()
+
+	// Initialize storage
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	store = metrics.InstrumentStorage(store)
+
+	metrics.Default.NewGaugeFunc("active_sessions", "Sessions that have not expired.", func() (float64, error) {
+		n, err := store.CountSessions()
+		return float64(n), err
+	})
+
+	// Initialize admin on first run
+	initResult, err := storage.Initialize(store, auth.HashPassword, auth.GenerateRandomPassword)
+	if err != nil {
+		return fmt.Errorf("failed to initialize admin: %w", err)
+	}
+
+	if initResult.AdminCreated {
+		// The generated password only goes to stdout, never to the structured log
+		slog.Warn("first run: admin account created", "email", initResult.AdminEmail)
+		fmt.Println("\n========================================")
+		fmt.Println("  FIRST RUN - Admin Account Created")
+		fmt.Println("========================================")
+		fmt.Printf("  Email:    %s\n", initResult.AdminEmail)
+		fmt.Printf("  Password: %s\n", initResult.AdminPassword)
+		fmt.Println("========================================")
+		fmt.Println("  Please save these credentials!")
+		fmt.Println("========================================")
+	}
+
+	// Only the version recorded by the last migration is read; checking every
+	// record is left to the migrate and check commands. Expired sessions are
+	// removed by the session-cleanup job.
+	version, err := store.StoredSchemaVersion()
+	if err != nil {
+		return fmt.Errorf("failed to read the data schema version: %w", err)
+	}
+	if version > storage.SchemaVersion() {
+		return fmt.Errorf("data is in schema version %d, newer than the latest this server knows (%d); upgrade the server or restore the backup taken by migrate", version, storage.SchemaVersion())
+	}
+	if version < storage.SchemaVersion() {
+		slog.Warn("data is in an older schema version; run 'server migrate'",
+			"version", version, "current", storage.SchemaVersion())
+	}
+
+	// Initialize backups and schedule automatic snapshots
+	backups, err := backup.NewManager(store, cfg.DataDir, cfg.BackupDir, cfg.BackupKeep)
+	if err != nil {
+		return fmt.Errorf("failed to initialize backups: %w", err)
+	}
+
+	// Email is queued in the outbox and retried until the transport accepts it
+	notifier, err := newNotifier(cfg, store)
+	if err != nil {
+		return fmt.Errorf("failed to initialize email: %w", err)
+	}
+	metrics.Default.NewGaugeFunc("outbox_pending", "Emails waiting in the outbox, including abandoned ones.", func() (float64, error) {
+		messages, err := store.GetOutboxMessages()
+		return float64(len(messages)), err
+	})
+
+	// Webhook deliveries are logged before they are sent and retried on failure
+	dispatcher := newDispatcher(cfg, store)
+
+	// Register recurring jobs; they start once the server is listening
+	scheduler := jobs.NewScheduler()
+	scheduler.Add("session-cleanup", cfg.SessionCleanupInterval, func(ctx context.Context) error {
+		return store.CleanExpiredSessions()
+	})
+	scheduler.Add("backup", cfg.BackupInterval, func(ctx context.Context) error {
+		_, err := backups.Create()
+		return err
+	})
+	scheduler.Add("outbox", cfg.MailFlushInterval, notifier.Flush)
+	scheduler.Add("webhooks", cfg.WebhookFlushInterval, dispatcher.Flush)
+	if cfg.WebhookRetention > 0 {
+		scheduler.Add("webhook-prune", 24*time.Hour, func(ctx context.Context) error {
+			n, err := store.PruneWebhookDeliveries(time.Now().Add(-cfg.WebhookRetention))
+			if n > 0 {
+				slog.Info("pruned webhook delivery log", "deliveries", n)
+			}
+			return err
+		})
+	}
+
+	// A zero interval turns reminders or the digest off
+	nudges := reminders.NewManager(store, notifier, reminders.Options{
+		InactiveDays:   cfg.ReminderInactiveDays,
+		Interval:       cfg.ReminderInterval,
+		DigestInterval: cfg.ReminderDigestInterval,
+	})
+	if cfg.ReminderInterval > 0 {
+		scheduler.Add("inactivity-reminders", cfg.ReminderCheckInterval, nudges.SendReminders)
+	}
+	if cfg.ReminderDigestInterval > 0 {
+		scheduler.Add("stalled-digest", cfg.ReminderCheckInterval, nudges.SendDigest)
+	}
+
+	// Templates and static files are embedded unless dev mode reads them from disk
+	templateFS, staticFS := web.Templates(), web.Static()
+	if cfg.DevMode {
+		templateFS = os.DirFS(filepath.Join(cfg.WebDir, "templates"))
+		staticFS = os.DirFS(filepath.Join(cfg.WebDir, "static"))
+		slog.Info("dev mode: reading templates and static files from disk", "dir", cfg.WebDir)
+	}
+
+	static, err := assets.New(staticFS, "/static/", cfg.DevMode)
+	if err != nil {
+		return err
+	}
+
+	// Initialize template renderer
+	renderer, err := templates.NewRenderer(templateFS, static, cfg.DevMode)
+	if err != nil {
+		return fmt.Errorf("failed to initialize templates: %w", err)
+	}
+
+	// Initialize session manager
+	sessions := auth.NewSessionManager(store, cfg.IsProd, cfg.SessionDuration, cfg.SessionMaxLifetime)
+
+	announcer := achievements.NewAnnouncer(notifier, achievements.Options{
+		EmailDriver:     cfg.AchievementEmailDriver,
+		EmailSupervisor: cfg.AchievementEmailSupervisor,
+	})
+
+	// Initialize handlers
+	authHandler := handlers.NewAuthHandler(store, sessions, renderer)
+	adminHandler := handlers.NewAdminHandler(store, sessions, renderer, handlers.AdminOptions{
+		ArchiveRetention:  cfg.ArchiveRetention,
+		DefaultDayHours:   cfg.DefaultDayHours,
+		DefaultNightHours: cfg.DefaultNightHours,
+		Achievements:      announcer,
+		Webhooks:          dispatcher,
+	})
+	driverHandler := handlers.NewDriverHandler(store, renderer, announcer, dispatcher)
+	backupHandler := handlers.NewBackupHandler(backups, renderer)
+	jobsHandler := handlers.NewJobsHandler(scheduler, renderer)
+	integrityHandler := handlers.NewIntegrityHandler(store, renderer)
+	webhooksHandler := handlers.NewWebhooksHandler(store, dispatcher, renderer)
+	healthHandler := handlers.NewHealthHandler(store, renderer)
+
+	// Set up router
+	r := chi.NewRouter()
+
+	// Global middleware
+	r.Use(chimiddleware.RealIP)
+	r.Use(logging.Middleware)
+	r.Use(logging.Recoverer)
+	r.Use(middleware.SecurityHeaders(middleware.SecurityOptions{
+		HSTS:          cfg.IsProd,
+		HSTSMaxAge:    cfg.HSTSMaxAge,
+		CSPReportOnly: cfg.CSPReportOnly,
+	}))
+	r.Use(i18n.Middleware)
+	r.Use(middleware.CSRFProtect(cfg.CSRFKey, cfg.IsProd))
+
+	// Static files
+	r.Handle("/static/*", http.StripPrefix("/static/", static.Handler()))
+
+	// Public routes
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		user, _ := sessions.GetUserFromSession(r)
+		if user == nil {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+		if user.IsAdmin() {
+			http.Redirect(w, r, "/admin", http.StatusSeeOther)
+		} else {
+			http.Redirect(w, r, "/driver", http.StatusSeeOther)
+		}
+	})
+
+	r.Get("/login", authHandler.LoginPage)
+	r.Post("/login", authHandler.Login)
+	r.Post("/logout", authHandler.Logout)
+
+	// Driver routes
+	r.Route("/driver", func(r chi.Router) {
+		r.Use(auth.RequireDriver(sessions))
+		r.Get("/", driverHandler.Dashboard)
+		r.Post("/log", driverHandler.LogHours)
+		r.Get("/profile", driverHandler.Profile)
+		r.Post("/profile", driverHandler.UpdateProfile)
+	})
+
+	// Admin routes
+	r.Route("/admin", func(r chi.Router) {
+		r.Use(auth.RequireAdmin(sessions))
+		r.Get("/", adminHandler.Dashboard)
+		r.Get("/users", adminHandler.ListUsers)
+		r.Get("/users/new", adminHandler.NewUserForm)
+		r.Post("/users", adminHandler.CreateUser)
+		r.Post("/users/bulk", adminHandler.BulkAction)
+		r.Get("/users/archived", adminHandler.ArchivedUsers)
+		r.Get("/users/{id}", adminHandler.ViewDriver)
+		r.Get("/users/{id}/edit", adminHandler.EditUserForm)
+		r.Post("/users/{id}", adminHandler.UpdateUser)
+		r.Post("/users/{id}/archive", adminHandler.ArchiveUser)
+		r.Post("/users/{id}/restore", adminHandler.RestoreUser)
+		r.Post("/users/{id}/purge", adminHandler.PurgeUser)
+		r.Get("/users/{id}/hours", adminHandler.EditHoursForm)
+		r.Post("/users/{id}/hours", adminHandler.UpdateHours)
+		r.Get("/users/{id}/export.csv", adminHandler.ExportDriverCSV)
+		r.Get("/profile", adminHandler.Profile)
+		r.Post("/profile", adminHandler.UpdateProfile)
+		r.Get("/backups", backupHandler.List)
+		r.Post("/backups", backupHandler.Create)
+		r.Get("/backups/{name}", backupHandler.Download)
+		r.Get("/jobs", jobsHandler.List)
+		r.Post("/jobs/{name}/run", jobsHandler.Run)
+		r.Get("/integrity", integrityHandler.Check)
+		r.Post("/integrity/repair", integrityHandler.Repair)
+		r.Get("/webhooks", webhooksHandler.List)
+		r.Get("/webhooks/new", webhooksHandler.NewForm)
+		r.Post("/webhooks", webhooksHandler.Create)
+		r.Get("/webhooks/{id}", webhooksHandler.View)
+		r.Get("/webhooks/{id}/edit", webhooksHandler.EditForm)
+		r.Post("/webhooks/{id}", webhooksHandler.Update)
+		r.Post("/webhooks/{id}/delete", webhooksHandler.Delete)
+		r.Post("/webhooks/{id}/test", webhooksHandler.Test)
+		r.Post("/webhooks/{id}/deliveries/{delivery}/redeliver", webhooksHandler.Redeliver)
+	})
+
+	// Probes and metrics sit outside the app router so they skip logging and CSRF
+	root := chi.NewRouter()
+	root.Use(metrics.Middleware)
+	root.Get("/healthz", healthHandler.Healthz)
+	root.Get("/readyz", healthHandler.Readyz)
+
+	var metricsServer *http.Server
+	switch {
+	case cfg.MetricsAddr != "":
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", metrics.Handler(metrics.Default, cfg.MetricsToken))
+		metricsServer = &http.Server{
+			Addr:              cfg.MetricsAddr,
+			Handler:           metricsMux,
+			ReadHeaderTimeout: cfg.ReadTimeout,
+		}
+	case cfg.MetricsToken != "":
+		root.Handle("/metrics", metrics.Handler(metrics.Default, cfg.MetricsToken))
+	default:
+		slog.Info("metrics disabled: set METRICS_TOKEN or METRICS_ADDR to expose /metrics")
+	}
+
+	root.Mount("/", r)
+
+	// Start server
+	addr := fmt.Sprintf(":%d", cfg.Port)
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           root,
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// With TLS configured, serve HTTPS and optionally redirect plain HTTP to it
+	var certs *tlscert.Reloader
+	var redirectServer *http.Server
+	if cfg.TLSCertFile != "" {
+		certs, err = tlscert.NewReloader(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return err
+		}
+		server.TLSConfig = certs.Config()
+
+		if cfg.TLSWatchInterval > 0 {
+			go certs.Watch(ctx, cfg.TLSWatchInterval)
+		}
+		go reloadOnHangup(ctx, certs)
+
+		if cfg.TLSRedirectAddr != "" {
+			redirectServer = &http.Server{
+				Addr: cfg.TLSRedirectAddr,
+				Handler: tlscert.RedirectHandler(cfg.Port, map[string]http.Handler{
+					"/healthz": http.HandlerFunc(healthHandler.Healthz),
+				}),
+				ReadHeaderTimeout: cfg.ReadTimeout,
+				IdleTimeout:       cfg.IdleTimeout,
+			}
+		}
+	}
+
+	scheduler.Start(ctx)
+
+	serverErr := make(chan error, 3)
+	go func() {
+		if certs != nil {
+			slog.Info("server starting", "addr", addr, "url", "https://localhost"+addr)
+			serverErr <- server.ListenAndServeTLS("", "")
+			return
+		}
+		slog.Info("server starting", "addr", addr, "url", "http://localhost"+addr)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	if redirectServer != nil {
+		go func() {
+			slog.Info("HTTP redirect listener starting", "addr", cfg.TLSRedirectAddr)
+			serverErr <- redirectServer.ListenAndServe()
+		}()
+	}
+
+	if metricsServer != nil {
+		go func() {
+			slog.Info("metrics listener starting", "addr", cfg.MetricsAddr)
+			serverErr <- metricsServer.ListenAndServe()
+		}()
+	}
+
+	select {
+	case err := <-serverErr:
+		scheduler.Stop()
+		return fmt.Errorf("server failed: %w", err)
+	case <-ctx.Done():
+	}
+
+	// Stop accepting connections and let in-flight requests finish
+	slog.Info("shutting down", "timeout", cfg.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("graceful shutdown incomplete", "error", err)
+	}
+	if redirectServer != nil {
+		redirectServer.Shutdown(shutdownCtx)
+	}
+	if metricsServer != nil {
+		metricsServer.Shutdown(shutdownCtx)
+	}
+
+	scheduler.Stop()
+	notifier.Wait()
+	dispatcher.Wait()
+	slog.Info("server stopped")
+	return nil
+}
+
+// reloadOnHangup reloads the TLS certificate each time the process gets SIGHUP
+func reloadOnHangup(ctx context.Context, certs *tlscert.Reloader) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			slog.Info("SIGHUP received, reloading TLS certificate")
+			if err := certs.Reload(); err != nil {
+				slog.Error("TLS certificate reload failed, keeping the current certificate", "error", err)
+			}
+		}
+	}
+}
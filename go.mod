@@ -7,6 +7,7 @@ require (
 	github.com/google/uuid v1.6.0
 	github.com/gorilla/csrf v1.7.3
 	golang.org/x/crypto v0.21.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -0,0 +1,137 @@
+package achievements
+
+import (
+	"sort"
+	"time"
+
+	"driving-hours/internal/models"
+)
+
+// Definition describes an achievement and when a driver has earned it.
+// Titles and descriptions are English message keys, translated when shown.
+type Definition struct {
+	ID          string
+	Title       string
+	Description string
+	earned      func(u *models.User) bool
+}
+
+// All lists every achievement in the order they are shown
+var All = []Definition{
+	define("day-25", "A quarter of the way", "Logged 25% of your required day hours", dayHours(0.25)),
+	define("day-50", "Halfway there", "Logged 50% of your required day hours", dayHours(0.5)),
+	define("day-75", "On the home stretch", "Logged 75% of your required day hours", dayHours(0.75)),
+	define("day-100", "Daytime done", "Logged all of your required day hours", dayHours(1)),
+	define("first-night", "Night owl", "Logged your first night drive", func(u *models.User) bool {
+		return u.TotalNightHours() > 0
+	}),
+	define("night-25", "Into the night", "Logged 25% of your required night hours", nightHours(0.25)),
+	define("night-50", "Halfway through the night", "Logged 50% of your required night hours", nightHours(0.5)),
+	define("night-75", "Almost dawn", "Logged 75% of your required night hours", nightHours(0.75)),
+	define("night-100", "Night hours done", "Logged all of your required night hours", nightHours(1)),
+	define("streak-4", "Four weeks in a row", "Drove every week for 4 weeks in a row", streak(4)),
+	define("streak-8", "Eight weeks in a row", "Drove every week for 8 weeks in a row", streak(8)),
+}
+
+func define(id, title, description string, earned func(u *models.User) bool) Definition {
+	return Definition{ID: id, Title: title, Description: description, earned: earned}
+}
+
+// dayHours is earned at a fraction of a day hours requirement. Drivers
+// without the requirement can't earn it.
+func dayHours(fraction float64) func(u *models.User) bool {
+	return func(u *models.User) bool {
+		return u.RequiredDayHours > 0 && u.TotalDayHours() >= fraction*u.RequiredDayHours
+	}
+}
+
+func nightHours(fraction float64) func(u *models.User) bool {
+	return func(u *models.User) bool {
+		return u.RequiredNightHours > 0 && u.TotalNightHours() >= fraction*u.RequiredNightHours
+	}
+}
+
+func streak(weeks int) func(u *models.User) bool {
+	return func(u *models.User) bool {
+		return LongestWeekStreak(u.DrivingLog) >= weeks
+	}
+}
+
+// Get returns the achievement with the given ID, or nil if there is none
+func Get(id string) *Definition {
+	for i := range All {
+		if All[i].ID == id {
+			return &All[i]
+		}
+	}
+	return nil
+}
+
+// Award adds every achievement u now qualifies for but hasn't earned yet and
+// returns them. The caller saves the user.
+func Award(u *models.User, now time.Time) []Definition {
+	var earned []Definition
+	for _, def := range All {
+		if u.Achievement(def.ID) != nil || !def.earned(u) {
+			continue
+		}
+		u.Achievements = append(u.Achievements, models.Achievement{ID: def.ID, EarnedAt: now})
+		earned = append(earned, def)
+	}
+	return earned
+}
+
+// Badge is an achievement as shown to a driver
+type Badge struct {
+	Definition
+	Earned   bool
+	EarnedAt time.Time
+}
+
+// Badges lists every achievement with whether u has earned it
+func Badges(u *models.User) []Badge {
+	badges := make([]Badge, len(All))
+	for i, def := range All {
+		badges[i] = Badge{Definition: def}
+		if a := u.Achievement(def.ID); a != nil {
+			badges[i].Earned = true
+			badges[i].EarnedAt = a.EarnedAt
+		}
+	}
+	return badges
+}
+
+// LongestWeekStreak is the most consecutive weeks, Monday to Sunday, with
+// hours logged in each
+func LongestWeekStreak(log models.DrivingLog) int {
+	weeks := make(map[time.Time]bool)
+	for date := range log {
+		if !log.HasEntry(date) {
+			continue
+		}
+		// Calendar dates, so UTC avoids daylight saving shifts
+		d, err := time.Parse(models.DateFormat, date)
+		if err != nil {
+			continue
+		}
+		offset := (int(d.Weekday()) + 6) % 7 // days since Monday
+		weeks[d.AddDate(0, 0, -offset)] = true
+	}
+
+	starts := make([]time.Time, 0, len(weeks))
+	for w := range weeks {
+		starts = append(starts, w)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+
+	longest, current := 0, 0
+	for i, w := range starts {
+		if i > 0 && w.Equal(starts[i-1].AddDate(0, 0, 7)) {
+			current++
+		} else {
+			current = 1
+		}
+		longest = max(longest, current)
+	}
+	return longest
+}
@@ -0,0 +1,55 @@
+package achievements
+
+import (
+	"context"
+
+	"driving-hours/internal/logging"
+	"driving-hours/internal/models"
+	"driving-hours/internal/notify"
+)
+
+// Sender queues an email, normally a *notify.Notifier
+type Sender interface {
+	Send(ctx context.Context, to notify.Recipient, template string, data notify.Data) error
+}
+
+// Options chooses who is emailed about new achievements
+type Options struct {
+	EmailDriver     bool
+	EmailSupervisor bool // only drivers with a supervisor email set
+}
+
+// Announcer emails drivers, and optionally their supervisors, about the
+// achievements they have just earned
+type Announcer struct {
+	sender Sender
+	opts   Options
+}
+
+func NewAnnouncer(sender Sender, opts Options) *Announcer {
+	return &Announcer{sender: sender, opts: opts}
+}
+
+// Announce sends one email per recipient listing everything in earned.
+// Failures are logged; they never undo the achievement.
+func (a *Announcer) Announce(ctx context.Context, driver *models.User, earned []Definition) {
+	if len(earned) == 0 {
+		return
+	}
+
+	data := notify.Data{"Driver": driver.Name, "Achievements": earned}
+
+	if a.opts.EmailDriver {
+		if err := a.sender.Send(ctx, notify.RecipientFor(driver), "achievement", data); err != nil {
+			logging.FromContext(ctx).Error("failed to send achievement email", "user_id", driver.ID, "error", err)
+		}
+	}
+
+	if a.opts.EmailSupervisor && driver.SupervisorEmail != "" {
+		// The supervisor has no account, so write in the driver's language
+		supervisor := notify.Recipient{Email: driver.SupervisorEmail, Locale: driver.Locale}
+		if err := a.sender.Send(ctx, supervisor, "achievement_supervisor", data); err != nil {
+			logging.FromContext(ctx).Error("failed to send supervisor achievement email", "user_id", driver.ID, "error", err)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package assets
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// hashLength is how many hex characters of the SHA-256 go into a file name
+const hashLength = 12
+
+// Assets serves static files and builds their URLs. Outside development mode
+// each URL carries a hash of the file contents, so responses can be cached
+// forever and a changed file gets a new URL.
+type Assets struct {
+	fsys   fs.FS
+	prefix string
+	dev    bool
+
+	hashed   map[string]string // "css/styles.css" -> "css/styles.0123456789ab.css"
+	original map[string]string // the reverse
+}
+
+// New indexes the files in fsys, which are served under prefix (e.g. "/static/").
+// In development mode nothing is hashed or cached, so edits show up on reload.
+func New(fsys fs.FS, prefix string, dev bool) (*Assets, error) {
+	a := &Assets{
+		fsys:     fsys,
+		prefix:   prefix,
+		dev:      dev,
+		hashed:   make(map[string]string),
+		original: make(map[string]string),
+	}
+	if dev {
+		return a, nil
+	}
+
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+
+		hashedName := hashedPath(name, hex.EncodeToString(sum[:])[:hashLength])
+		a.hashed[name] = hashedName
+		a.original[hashedName] = name
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to index static files: %w", err)
+	}
+
+	return a, nil
+}
+
+// hashedPath inserts hash before the extension: css/styles.css -> css/styles.<hash>.css
+func hashedPath(name, hash string) string {
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hash + ext
+}
+
+// URL returns the URL of a static file such as "css/styles.css"
+func (a *Assets) URL(name string) string {
+	name = strings.TrimPrefix(name, "/")
+	if hashedName, ok := a.hashed[name]; ok {
+		return a.prefix + hashedName
+	}
+	return a.prefix + name
+}
+
+// Handler serves the static files. It expects the prefix to be stripped
+// already. Hashed URLs are cached for a year; anything else must revalidate.
+func (a *Assets) Handler() http.Handler {
+	files := http.FileServer(http.FS(a.fsys))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/")
+
+		if originalName, ok := a.original[name]; ok {
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+			r = r.Clone(r.Context())
+			r.URL.Path = "/" + originalName
+			files.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-cache")
+		files.ServeHTTP(w, r)
+	})
+}
@@ -6,19 +6,39 @@ import (
 	"encoding/base64"
 	"fmt"
 	"strings"
+	"time"
 
 	"golang.org/x/crypto/argon2"
+
+	"driving-hours/internal/metrics"
 )
 
-// Argon2id parameters (BitWarden defaults)
 const (
-	argonMemory      = 64 * 1024 // 64 MB
-	argonIterations  = 3
-	argonParallelism = 4
-	argonSaltLength  = 16
-	argonKeyLength   = 32
+	argonSaltLength = 16
+	argonKeyLength  = 32
 )
 
+// Argon2Params are the Argon2id cost parameters. Memory is in KiB.
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+// argonParams apply to new hashes (BitWarden defaults). Existing hashes carry
+// their own parameters, so changing these does not affect verification.
+var argonParams = Argon2Params{
+	Memory:      64 * 1024, // 64 MB
+	Iterations:  3,
+	Parallelism: 4,
+}
+
+// SetArgon2Params sets the parameters used by HashPassword. Call it once at
+// startup, before any hashing.
+func SetArgon2Params(p Argon2Params) {
+	argonParams = p
+}
+
 // HashPassword creates an Argon2id hash of the password
 func HashPassword(password string) (string, error) {
 	salt := make([]byte, argonSaltLength)
@@ -26,12 +46,15 @@ func HashPassword(password string) (string, error) {
 		return "", err
 	}
 
+	defer metrics.Since(metrics.PasswordHashDuration, time.Now(), "hash")
+
+	params := argonParams
 	hash := argon2.IDKey(
 		[]byte(password),
 		salt,
-		argonIterations,
-		argonMemory,
-		argonParallelism,
+		params.Iterations,
+		params.Memory,
+		params.Parallelism,
 		argonKeyLength,
 	)
 
@@ -42,9 +65,9 @@ func HashPassword(password string) (string, error) {
 	encoded := fmt.Sprintf(
 		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
 		argon2.Version,
-		argonMemory,
-		argonIterations,
-		argonParallelism,
+		params.Memory,
+		params.Iterations,
+		params.Parallelism,
 		b64Salt,
 		b64Hash,
 	)
@@ -86,6 +109,8 @@ func VerifyPassword(password, encodedHash string) (bool, error) {
 		return false, err
 	}
 
+	defer metrics.Since(metrics.PasswordHashDuration, time.Now(), "verify")
+
 	computedHash := argon2.IDKey(
 		[]byte(password),
 		salt,
@@ -4,6 +4,8 @@ import (
 	"context"
 	"net/http"
 
+	"driving-hours/internal/i18n"
+	"driving-hours/internal/logging"
 	"driving-hours/internal/models"
 )
 
@@ -15,12 +17,17 @@ const UserContextKey contextKey = "user"
 func RequireAuth(sm *SessionManager) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			user, err := sm.GetUserFromSession(r)
+			user, err := sm.Authenticate(w, r)
+			if err != nil {
+				logging.FromContext(r.Context()).Error("failed to load session user", "error", err)
+			}
 			if err != nil || user == nil {
 				http.Redirect(w, r, "/login", http.StatusSeeOther)
 				return
 			}
 
+			logging.SetUserID(r.Context(), user.ID)
+			i18n.SetPreference(r.Context(), user.Locale)
 			ctx := context.WithValue(r.Context(), UserContextKey, user)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
@@ -31,7 +38,10 @@ func RequireAuth(sm *SessionManager) func(http.Handler) http.Handler {
 func RequireAdmin(sm *SessionManager) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			user, err := sm.GetUserFromSession(r)
+			user, err := sm.Authenticate(w, r)
+			if err != nil {
+				logging.FromContext(r.Context()).Error("failed to load session user", "error", err)
+			}
 			if err != nil || user == nil {
 				http.Redirect(w, r, "/login", http.StatusSeeOther)
 				return
@@ -42,6 +52,8 @@ func RequireAdmin(sm *SessionManager) func(http.Handler) http.Handler {
 				return
 			}
 
+			logging.SetUserID(r.Context(), user.ID)
+			i18n.SetPreference(r.Context(), user.Locale)
 			ctx := context.WithValue(r.Context(), UserContextKey, user)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
@@ -52,7 +64,10 @@ func RequireAdmin(sm *SessionManager) func(http.Handler) http.Handler {
 func RequireDriver(sm *SessionManager) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			user, err := sm.GetUserFromSession(r)
+			user, err := sm.Authenticate(w, r)
+			if err != nil {
+				logging.FromContext(r.Context()).Error("failed to load session user", "error", err)
+			}
 			if err != nil || user == nil {
 				http.Redirect(w, r, "/login", http.StatusSeeOther)
 				return
@@ -63,6 +78,8 @@ func RequireDriver(sm *SessionManager) func(http.Handler) http.Handler {
 				return
 			}
 
+			logging.SetUserID(r.Context(), user.ID)
+			i18n.SetPreference(r.Context(), user.Locale)
 			ctx := context.WithValue(r.Context(), UserContextKey, user)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
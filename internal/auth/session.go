@@ -6,25 +6,36 @@ import (
 	"net/http"
 	"time"
 
+	"driving-hours/internal/logging"
 	"driving-hours/internal/models"
 	"driving-hours/internal/storage"
 )
 
 const (
 	SessionCookieName = "session"
-	SessionDuration   = 7 * 24 * time.Hour // 7 days
 	TokenLength       = 32
 )
 
+// refreshInterval is how long a session is used without being renewed, so
+// that most requests only read it
+const refreshInterval = time.Minute
+
+// SessionManager signs users in with a random token in a cookie. Sessions
+// slide: each use extends them by duration, up to maxLifetime after sign-in
+// when that is set, so a login ends after duration without any requests.
 type SessionManager struct {
-	storage  storage.Storage
-	secure   bool
+	storage     storage.Storage
+	secure      bool
+	duration    time.Duration
+	maxLifetime time.Duration
 }
 
-func NewSessionManager(storage storage.Storage, secure bool) *SessionManager {
+func NewSessionManager(storage storage.Storage, secure bool, duration, maxLifetime time.Duration) *SessionManager {
 	return &SessionManager{
-		storage: storage,
-		secure:  secure,
+		storage:     storage,
+		secure:      secure,
+		duration:    duration,
+		maxLifetime: maxLifetime,
 	}
 }
 
@@ -46,26 +57,18 @@ func (sm *SessionManager) CreateSession(w http.ResponseWriter, userID string) er
 
 	now := time.Now()
 	session := &models.Session{
-		Token:     token,
-		UserID:    userID,
-		ExpiresAt: now.Add(SessionDuration),
-		CreatedAt: now,
+		TokenHash:  models.HashSessionToken(token),
+		UserID:     userID,
+		LastSeenAt: now,
+		CreatedAt:  now,
 	}
+	session.ExpiresAt = sm.expiresAt(session, now)
 
 	if err := sm.storage.SaveSession(session); err != nil {
 		return err
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     SessionCookieName,
-		Value:    token,
-		Path:     "/",
-		Expires:  session.ExpiresAt,
-		HttpOnly: true,
-		Secure:   sm.secure,
-		SameSite: http.SameSiteLaxMode,
-	})
-
+	sm.setCookie(w, token, session.ExpiresAt)
 	return nil
 }
 
@@ -76,7 +79,61 @@ func (sm *SessionManager) GetSession(r *http.Request) (*models.Session, error) {
 		return nil, nil
 	}
 
-	return sm.storage.GetSession(cookie.Value)
+	return sm.storage.GetSession(models.HashSessionToken(cookie.Value))
+}
+
+// Authenticate returns the signed-in user like GetUserFromSession and
+// extends their session, updating the cookie to match
+func (sm *SessionManager) Authenticate(w http.ResponseWriter, r *http.Request) (*models.User, error) {
+	session, err := sm.GetSession(r)
+	if err != nil || session == nil {
+		return nil, err
+	}
+
+	user, err := sm.userFor(session)
+	if err != nil || user == nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if now.Sub(session.LastSeenAt) >= refreshInterval {
+		session.LastSeenAt = now
+		session.ExpiresAt = sm.expiresAt(session, now)
+		// The session is still valid for this request even if it can't be
+		// extended. One signed out meanwhile stays signed out.
+		refreshed, err := sm.storage.RefreshSession(session)
+		if err != nil {
+			logging.FromContext(r.Context()).Warn("failed to extend session", "error", err)
+		} else if refreshed {
+			cookie, _ := r.Cookie(SessionCookieName)
+			sm.setCookie(w, cookie.Value, session.ExpiresAt)
+		}
+	}
+
+	return user, nil
+}
+
+// expiresAt is when session ends if it isn't used again after now
+func (sm *SessionManager) expiresAt(session *models.Session, now time.Time) time.Time {
+	expires := now.Add(sm.duration)
+	if sm.maxLifetime > 0 {
+		if limit := session.CreatedAt.Add(sm.maxLifetime); limit.Before(expires) {
+			return limit
+		}
+	}
+	return expires
+}
+
+func (sm *SessionManager) setCookie(w http.ResponseWriter, token string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   sm.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
 }
 
 // DestroySession removes the session and clears the cookie
@@ -86,7 +143,7 @@ func (sm *SessionManager) DestroySession(w http.ResponseWriter, r *http.Request)
 		return nil
 	}
 
-	if err := sm.storage.DeleteSession(cookie.Value); err != nil {
+	if err := sm.storage.DeleteSession(models.HashSessionToken(cookie.Value)); err != nil {
 		return err
 	}
 
@@ -110,7 +167,12 @@ func (sm *SessionManager) GetUserFromSession(r *http.Request) (*models.User, err
 	if err != nil || session == nil {
 		return nil, err
 	}
+	return sm.userFor(session)
+}
 
+// userFor loads the user a session belongs to, or nil if they can no longer
+// sign in
+func (sm *SessionManager) userFor(session *models.Session) (*models.User, error) {
 	// Check admin first
 	admin, err := sm.storage.GetAdmin()
 	if err != nil {
@@ -121,5 +183,15 @@ func (sm *SessionManager) GetUserFromSession(r *http.Request) (*models.User, err
 	}
 
 	// Check regular users
-	return sm.storage.GetUser(session.UserID)
+	user, err := sm.storage.GetUser(session.UserID)
+	if err != nil || user == nil {
+		return nil, err
+	}
+
+	// Archived users keep their data but can no longer sign in
+	if user.IsArchived() {
+		return nil, nil
+	}
+
+	return user, nil
 }
@@ -0,0 +1,323 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"driving-hours/internal/storage"
+)
+
+const (
+	filePrefix   = "backup-"
+	fileSuffix   = ".tar.gz"
+	timeLayout   = "20060102-150405.000"
+	manifestName = "manifest.json"
+)
+
+// legacyTimeLayout named backups to the second, before two backups taken in
+// the same second could replace each other
+const legacyTimeLayout = "20060102-150405"
+
+// Snapshotter runs fn while guaranteeing the data directory does not change
+type Snapshotter interface {
+	Snapshot(fn func() error) error
+}
+
+// Info describes a backup archive on disk
+type Info struct {
+	Name      string
+	Size      int64
+	CreatedAt time.Time
+}
+
+// manifest is stored at the end of every archive and lists a checksum for each file
+type manifest struct {
+	CreatedAt time.Time         `json:"created_at"`
+	Files     map[string]string `json:"files"`
+}
+
+// Manager creates, lists and prunes backups of the data directory
+type Manager struct {
+	store     Snapshotter
+	dataDir   string
+	backupDir string
+	keep      int
+}
+
+// NewManager creates a backup manager. keep is the number of archives to retain;
+// zero or less keeps every archive.
+func NewManager(store Snapshotter, dataDir, backupDir string, keep int) (*Manager, error) {
+	if err := os.MkdirAll(backupDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	return &Manager{
+		store:     store,
+		dataDir:   dataDir,
+		backupDir: backupDir,
+		keep:      keep,
+	}, nil
+}
+
+// Create writes a new timestamped archive of the data directory and prunes old ones
+func (m *Manager) Create() (*Info, error) {
+	now := time.Now()
+
+	// Write to a temp file and rename, like JSONStorage.writeFile
+	tmpFile, err := os.CreateTemp(m.backupDir, ".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	tmpPath := tmpFile.Name()
+
+	err = m.store.Snapshot(func() error {
+		return m.writeArchive(tmpFile, now)
+	})
+	if err != nil {
+		tmpFile.Close()
+		os.Remove(tmpPath)
+		return nil, err
+	}
+
+	if err := tmpFile.Chmod(0600); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpPath)
+		return nil, err
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		os.Remove(tmpPath)
+		return nil, err
+	}
+
+	name, err := publish(tmpPath, m.backupDir, now)
+	os.Remove(tmpPath)
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(m.backupDir, name)
+
+	if err := m.prune(); err != nil {
+		return nil, fmt.Errorf("backup created but pruning failed: %w", err)
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Info{Name: name, Size: stat.Size(), CreatedAt: now}, nil
+}
+
+// publish gives the finished archive at tmpPath its timestamped name. Like
+// creating it with O_EXCL, linking never replaces an existing backup; a name
+// that is taken gets a counter instead.
+func publish(tmpPath, backupDir string, createdAt time.Time) (string, error) {
+	stamp := createdAt.UTC().Format(timeLayout)
+	for n := 1; ; n++ {
+		name := filePrefix + stamp + fileSuffix
+		if n > 1 {
+			name = fmt.Sprintf("%s%s-%d%s", filePrefix, stamp, n, fileSuffix)
+		}
+		err := os.Link(tmpPath, filepath.Join(backupDir, name))
+		if err == nil {
+			return name, nil
+		}
+		if !os.IsExist(err) {
+			return "", err
+		}
+	}
+}
+
+// parseName returns when a backup was taken from its name
+func parseName(name string) (time.Time, error) {
+	stamp := strings.TrimSuffix(strings.TrimPrefix(name, filePrefix), fileSuffix)
+	if createdAt, err := time.Parse(legacyTimeLayout, stamp); err == nil {
+		return createdAt, nil
+	}
+	// Drop the counter added when the name was taken
+	if i := strings.LastIndexByte(stamp, '-'); i > len(legacyTimeLayout) {
+		stamp = stamp[:i]
+	}
+	return time.Parse(timeLayout, stamp)
+}
+
+func (m *Manager) writeArchive(w io.Writer, createdAt time.Time) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	mf := manifest{CreatedAt: createdAt, Files: make(map[string]string)}
+
+	err := filepath.WalkDir(m.dataDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(m.dataDir, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+
+		if m.skip(path, d.Name()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if d.IsDir() || !d.Type().IsRegular() {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		name := filepath.ToSlash(rel)
+		if err := writeEntry(tw, name, data); err != nil {
+			return err
+		}
+
+		sum := sha256.Sum256(data)
+		mf.Files[name] = hex.EncodeToString(sum[:])
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	manifestData, err := json.MarshalIndent(mf, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeEntry(tw, manifestName, manifestData); err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// skip reports whether a path inside the data directory is excluded from backups
+func (m *Manager) skip(path, name string) bool {
+	if sameDir(path, m.backupDir) {
+		return true
+	}
+	return name == storage.LockFileName ||
+		strings.HasPrefix(name, ".tmp-") ||
+		strings.HasPrefix(name, restorePrefix) ||
+		strings.HasPrefix(name, preRestorePrefix)
+}
+
+func writeEntry(tw *tar.Writer, name string, data []byte) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// List returns the backups on disk, newest first
+func (m *Manager) List() ([]Info, error) {
+	entries, err := os.ReadDir(m.backupDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var backups []Info
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !validName(name) {
+			continue
+		}
+
+		createdAt, err := parseName(name)
+		if err != nil {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		backups = append(backups, Info{
+			Name:      name,
+			Size:      info.Size(),
+			CreatedAt: createdAt.Local(),
+		})
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].CreatedAt.After(backups[j].CreatedAt)
+	})
+
+	return backups, nil
+}
+
+// Path returns the full path of a backup by name, rejecting anything that is not a backup file
+func (m *Manager) Path(name string) (string, error) {
+	if !validName(name) || filepath.Base(name) != name {
+		return "", fmt.Errorf("invalid backup name: %s", name)
+	}
+	path := filepath.Join(m.backupDir, name)
+	if _, err := os.Stat(path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// prune removes the oldest archives beyond the retention count
+func (m *Manager) prune() error {
+	if m.keep <= 0 {
+		return nil
+	}
+
+	backups, err := m.List()
+	if err != nil {
+		return err
+	}
+
+	for i := m.keep; i < len(backups); i++ {
+		if err := os.Remove(filepath.Join(m.backupDir, backups[i].Name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func validName(name string) bool {
+	return strings.HasPrefix(name, filePrefix) && strings.HasSuffix(name, fileSuffix)
+}
+
+func sameDir(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
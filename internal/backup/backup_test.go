@@ -0,0 +1,139 @@
+package backup
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"driving-hours/internal/models"
+	"driving-hours/internal/storage"
+)
+
+func TestRestoreEncryptedBackup(t *testing.T) {
+	dataDir := t.TempDir()
+	backupDir := filepath.Join(dataDir, "backups")
+
+	key, err := storage.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawKey, err := storage.ParseKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cipher, err := storage.NewCipher(rawKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := storage.NewJSONStorage(dataDir, cipher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	admin := &models.User{ID: "admin", Email: "admin@example.com", Name: "Admin", Role: models.RoleAdmin, CreatedAt: now}
+	if err := store.SaveAdmin(admin); err != nil {
+		t.Fatal(err)
+	}
+	driver := &models.User{ID: "driver", Email: "driver@example.com", Name: "Before", Role: models.RoleDriver, CreatedAt: now}
+	if err := store.SaveUser(driver); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.PutLogEntry(driver.ID, "2026-01-02", models.DayEntry{DayHours: 1.5}); err != nil {
+		t.Fatal(err)
+	}
+
+	backups, err := NewManager(store, dataDir, backupDir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := backups.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(backupDir, info.Name)
+	if err := Validate(archive); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	// Change the data after the backup, then restore it
+	driver.Name = "After"
+	if err := store.SaveUser(driver); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteLogEntry(driver.ID, "2026-01-02"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Restore(archive, dataDir, backupDir); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+
+	restored, err := storage.NewJSONStorage(dataDir, cipher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := restored.GetUser(driver.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.Name != "Before" {
+		t.Fatalf("restored driver = %+v, want name Before", got)
+	}
+	if entry := got.DrivingLog.GetEntry("2026-01-02"); entry.DayHours != 1.5 {
+		t.Errorf("restored log entry = %+v, want 1.5 day hours", entry)
+	}
+
+	// The restored records are still encrypted
+	plain, err := storage.NewJSONStorage(dataDir, nil)
+	if err == nil {
+		if _, err := plain.GetUser(driver.ID); err == nil {
+			t.Error("read an encrypted record without the key")
+		}
+	}
+}
+
+func TestBackupsInTheSameMillisecondKeepDistinctNames(t *testing.T) {
+	backupDir := t.TempDir()
+	createdAt := time.Date(2026, 1, 2, 3, 4, 5, 6e6, time.UTC)
+
+	names := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		tmp := filepath.Join(backupDir, ".tmp-archive")
+		if err := os.WriteFile(tmp, []byte{byte(i)}, 0600); err != nil {
+			t.Fatal(err)
+		}
+		name, err := publish(tmp, backupDir, createdAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.Remove(tmp)
+		if names[name] {
+			t.Fatalf("name %s was used twice", name)
+		}
+		names[name] = true
+
+		got, err := parseName(name)
+		if err != nil || !got.Equal(createdAt) {
+			t.Errorf("parseName(%s) = %v, %v, want %v", name, got, err, createdAt)
+		}
+	}
+
+	if got, err := parseName("backup-20260102-030405.tar.gz"); err != nil || !got.Equal(createdAt.Truncate(time.Second)) {
+		t.Errorf("parseName of a name without milliseconds = %v, %v", got, err)
+	}
+}
+
+func TestRestoreFailsWhileDataDirIsLocked(t *testing.T) {
+	dataDir := t.TempDir()
+	lock, err := storage.LockDataDir(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Unlock()
+
+	if _, err := Restore(filepath.Join(t.TempDir(), "backup.tar.gz"), dataDir, t.TempDir()); !errors.Is(err, storage.ErrLocked) {
+		t.Fatalf("Restore = %v, want ErrLocked", err)
+	}
+}
@@ -0,0 +1,201 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"driving-hours/internal/storage"
+)
+
+const (
+	restorePrefix    = ".restore-"
+	preRestorePrefix = ".pre-restore-"
+)
+
+// Validate checks that an archive is readable, contains an admin record, and
+// that every file matches the checksum recorded in its manifest
+func Validate(archivePath string) error {
+	return readArchive(archivePath, func(name string, data []byte) error {
+		return nil
+	})
+}
+
+// Restore validates an archive and swaps its contents into the data directory.
+// It holds the data directory's lock throughout, so it fails with
+// storage.ErrLocked while the server is running. Replaced files are kept in a
+// .pre-restore-* directory inside the data directory so the restore can be
+// undone by hand.
+func Restore(archivePath, dataDir, backupDir string) (string, error) {
+	lock, err := storage.LockDataDir(dataDir)
+	if err != nil {
+		return "", err
+	}
+	defer lock.Unlock()
+
+	stamp := time.Now().UTC().Format(legacyTimeLayout)
+	stagingDir, err := os.MkdirTemp(dataDir, restorePrefix+stamp+"-")
+	if err != nil {
+		return "", err
+	}
+
+	// Extract into a staging directory first so a bad archive never touches live data
+	err = readArchive(archivePath, func(name string, data []byte) error {
+		target := filepath.Join(stagingDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+			return err
+		}
+		return os.WriteFile(target, data, 0600)
+	})
+	if err != nil {
+		os.RemoveAll(stagingDir)
+		return "", err
+	}
+
+	previousDir, err := os.MkdirTemp(dataDir, preRestorePrefix+stamp+"-")
+	if err != nil {
+		os.RemoveAll(stagingDir)
+		return "", err
+	}
+
+	// Move current top-level entries aside, leaving backups and restore dirs in place
+	current, err := os.ReadDir(dataDir)
+	if err != nil {
+		os.RemoveAll(stagingDir)
+		return "", err
+	}
+
+	var moved []string
+	rollback := func() {
+		for _, name := range moved {
+			os.RemoveAll(filepath.Join(dataDir, name))
+			os.Rename(filepath.Join(previousDir, name), filepath.Join(dataDir, name))
+		}
+		os.RemoveAll(stagingDir)
+	}
+
+	for _, entry := range current {
+		name := entry.Name()
+		if sameDir(filepath.Join(dataDir, name), backupDir) ||
+			name == storage.LockFileName ||
+			strings.HasPrefix(name, restorePrefix) ||
+			strings.HasPrefix(name, preRestorePrefix) {
+			continue
+		}
+		if err := os.Rename(filepath.Join(dataDir, name), filepath.Join(previousDir, name)); err != nil {
+			rollback()
+			return "", err
+		}
+		moved = append(moved, name)
+	}
+
+	staged, err := os.ReadDir(stagingDir)
+	if err != nil {
+		rollback()
+		return "", err
+	}
+
+	for _, entry := range staged {
+		name := entry.Name()
+		if err := os.Rename(filepath.Join(stagingDir, name), filepath.Join(dataDir, name)); err != nil {
+			rollback()
+			return "", err
+		}
+	}
+
+	os.RemoveAll(stagingDir)
+	return previousDir, nil
+}
+
+// readArchive streams every file in an archive to fn, then verifies the manifest
+func readArchive(archivePath string, fn func(name string, data []byte) error) error {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("not a gzip archive: %w", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	sums := make(map[string]string)
+	var mf *manifest
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("corrupt archive: %w", err)
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			return fmt.Errorf("unexpected entry type in archive: %s", header.Name)
+		}
+
+		name := path.Clean(header.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("unsafe path in archive: %s", header.Name)
+		}
+
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return fmt.Errorf("corrupt archive: %w", err)
+		}
+
+		if name == manifestName {
+			mf = &manifest{}
+			if err := json.Unmarshal(data, mf); err != nil {
+				return fmt.Errorf("invalid manifest: %w", err)
+			}
+			continue
+		}
+
+		// Encrypted records can't be parsed without the key; the manifest
+		// checksums still catch damage to them
+		if strings.HasSuffix(name, ".json") && !storage.IsEncrypted(data) && !json.Valid(data) {
+			return fmt.Errorf("invalid JSON in archive: %s", name)
+		}
+
+		sum := sha256.Sum256(data)
+		sums[name] = hex.EncodeToString(sum[:])
+
+		if err := fn(name, data); err != nil {
+			return err
+		}
+	}
+
+	if mf == nil {
+		return errors.New("archive has no manifest")
+	}
+
+	if _, ok := sums["admin.json"]; !ok {
+		return errors.New("archive does not contain admin.json")
+	}
+
+	if len(sums) != len(mf.Files) {
+		return fmt.Errorf("archive has %d files but manifest lists %d", len(sums), len(mf.Files))
+	}
+	for name, want := range mf.Files {
+		if sums[name] != want {
+			return fmt.Errorf("checksum mismatch for %s", name)
+		}
+	}
+
+	return nil
+}
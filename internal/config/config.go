@@ -6,47 +6,247 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"strconv"
+	"time"
 )
 
 type Config struct {
-	Port      int
-	DataDir   string
-	CSRFKey   []byte
-	IsProd    bool
+	Port    int
+	DataDir string
+	CSRFKey []byte
+	IsProd  bool
+
+	// BaseURL is the public address of the site, used for links in emails
+	BaseURL string
+
+	// EncryptionKey or the key in EncryptionKeyFile, both base64, encrypts
+	// the files in DataDir. Files written before it was set stay readable.
+	EncryptionKey     string
+	EncryptionKeyFile string
+
+	// DevMode reads templates and static files from WebDir instead of the
+	// copies embedded in the binary
+	DevMode bool
+	WebDir  string
+
+	// ArchiveRetention is how long a user must stay archived before they can be purged
+	ArchiveRetention time.Duration
+
+	// BackupDir holds backup archives; BackupKeep is how many to retain and
+	// BackupInterval how often to take one (zero disables scheduled backups)
+	BackupDir      string
+	BackupKeep     int
+	BackupInterval time.Duration
+
+	// TLSCertFile and TLSKeyFile enable HTTPS on Port. The files are reloaded on
+	// SIGHUP and when they change, checked every TLSWatchInterval.
+	// TLSRedirectAddr, when set, listens for plain HTTP and redirects to HTTPS.
+	TLSCertFile      string
+	TLSKeyFile       string
+	TLSRedirectAddr  string
+	TLSWatchInterval time.Duration
+
+	// HTTP server timeouts and how long to wait for requests to drain on shutdown
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	IdleTimeout     time.Duration
+	ShutdownTimeout time.Duration
+
+	// SessionDuration is how long a login lasts without being used; each
+	// request extends it, up to SessionMaxLifetime after signing in (0 means
+	// no limit). SessionCleanupInterval is how often expired sessions are
+	// removed.
+	SessionDuration        time.Duration
+	SessionMaxLifetime     time.Duration
+	SessionCleanupInterval time.Duration
+
+	// Argon2id cost parameters for new password hashes. Memory is in KiB.
+	Argon2Memory      uint32
+	Argon2Iterations  uint32
+	Argon2Parallelism uint8
+
+	// Timezone is the organisation default for users who have not chosen
+	// one: an IANA name such as "America/Chicago", or "Local" for the server's
+	Timezone string
+
+	// DefaultLocale is the interface language for users who have not chosen
+	// one and whose browser asks for none that is available
+	DefaultLocale string
+
+	// DefaultDayHours and DefaultNightHours prefill the requirements of new drivers
+	DefaultDayHours   float64
+	DefaultNightHours float64
+
+	// MailTransport is "smtp", or "file" to write each email to MailDir
+	// instead of sending it. Undelivered mail is retried every MailFlushInterval,
+	// waiting MailRetryDelay (doubling each time) and giving up after
+	// MailMaxAttempts.
+	MailTransport     string
+	MailFrom          string
+	MailDir           string
+	MailFlushInterval time.Duration
+	MailRetryDelay    time.Duration
+	MailMaxAttempts   int
+
+	// Drivers who haven't logged hours for ReminderInactiveDays get a reminder,
+	// at most once per ReminderInterval, and the admin gets a digest of them at
+	// most once per ReminderDigestInterval. Both are checked every
+	// ReminderCheckInterval.
+	ReminderInactiveDays   int
+	ReminderInterval       time.Duration
+	ReminderDigestInterval time.Duration
+	ReminderCheckInterval  time.Duration
+
+	// New achievements are emailed to the driver and, if they have one, their
+	// supervisor
+	AchievementEmailDriver     bool
+	AchievementEmailSupervisor bool
+
+	// Webhook requests time out after WebhookTimeout. Failed deliveries are
+	// retried every WebhookFlushInterval, waiting WebhookRetryDelay (doubling
+	// each time) and giving up after WebhookMaxAttempts. The delivery log is
+	// kept for WebhookRetention.
+	WebhookTimeout       time.Duration
+	WebhookMaxAttempts   int
+	WebhookRetryDelay    time.Duration
+	WebhookFlushInterval time.Duration
+	WebhookRetention     time.Duration
+
+	// SMTP server for the smtp transport. SMTPTLS is starttls, tls or none.
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	SMTPTLS      string
+
+	// HSTSMaxAge is sent in Strict-Transport-Security in production.
+	// CSPReportOnly reports Content-Security-Policy violations without blocking.
+	HSTSMaxAge    time.Duration
+	CSPReportOnly bool
+
+	// MetricsToken, when set, is required as a bearer token on /metrics.
+	// MetricsAddr, when set, serves /metrics on a separate listener instead.
+	MetricsToken string
+	MetricsAddr  string
+
+	// LogFormat is "text" or "json"; LogLevel is debug, info, warn or error
+	LogFormat string
+	LogLevel  string
+}
+
+// Default returns the configuration used when nothing is set
+func Default() *Config {
+	return &Config{
+		Port:    8080,
+		DataDir: "data",
+		WebDir:  "web",
+
+		ArchiveRetention: 30 * 24 * time.Hour,
+
+		BackupKeep:     7,
+		BackupInterval: 24 * time.Hour,
+
+		TLSWatchInterval: time.Minute,
+
+		ReadTimeout:     15 * time.Second,
+		WriteTimeout:    30 * time.Second,
+		IdleTimeout:     120 * time.Second,
+		ShutdownTimeout: 30 * time.Second,
+
+		SessionDuration:        7 * 24 * time.Hour,
+		SessionMaxLifetime:     30 * 24 * time.Hour,
+		SessionCleanupInterval: time.Hour,
+
+		// BitWarden defaults
+		Argon2Memory:      64 * 1024,
+		Argon2Iterations:  3,
+		Argon2Parallelism: 4,
+
+		MailTransport:     "file",
+		MailFrom:          "Driving Hours <noreply@localhost>",
+		MailFlushInterval: time.Minute,
+		MailRetryDelay:    5 * time.Minute,
+		MailMaxAttempts:   8,
+		SMTPPort:          587,
+		SMTPTLS:           "starttls",
+
+		ReminderInactiveDays:   14,
+		ReminderInterval:       7 * 24 * time.Hour,
+		ReminderDigestInterval: 7 * 24 * time.Hour,
+		ReminderCheckInterval:  time.Hour,
+
+		AchievementEmailDriver:     true,
+		AchievementEmailSupervisor: true,
+
+		WebhookTimeout:       10 * time.Second,
+		WebhookMaxAttempts:   6,
+		WebhookRetryDelay:    time.Minute,
+		WebhookFlushInterval: time.Minute,
+		WebhookRetention:     30 * 24 * time.Hour,
+
+		HSTSMaxAge: 365 * 24 * time.Hour,
+
+		Timezone:      "Local",
+		DefaultLocale: "en",
+
+		LogFormat: "text",
+		LogLevel:  "info",
+	}
 }
 
-func Load() (*Config, error) {
-	port := 8080
-	if p := os.Getenv("PORT"); p != "" {
-		if parsed, err := strconv.Atoi(p); err == nil {
-			port = parsed
+// Load builds the configuration from the defaults, then the config file (if
+// any), then environment variables. The file is path, or CONFIG_FILE when path
+// is empty. Every invalid value is reported, not just the first.
+func Load(path string) (*Config, error) {
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+
+	cfg := Default()
+	raw := rawValues{environment: "development", archiveDays: 30}
+	settings := cfg.settings(&raw)
+
+	var errs []string
+	if path != "" {
+		errs = append(errs, loadFile(path, settings)...)
+	}
+	errs = append(errs, loadEnv(settings)...)
+
+	cfg.IsProd = raw.environment == "production"
+	cfg.ArchiveRetention = time.Duration(raw.archiveDays) * 24 * time.Hour
+	if cfg.BackupDir == "" {
+		cfg.BackupDir = filepath.Join(cfg.DataDir, "backups")
+	}
+	if cfg.MailDir == "" {
+		// Next to the data directory rather than in it: the emails hold
+		// drivers' names and addresses in plain text, which shouldn't end up
+		// in backups or sit unencrypted among encrypted records
+		cfg.MailDir = filepath.Join(filepath.Dir(filepath.Clean(cfg.DataDir)), "mail")
+	}
+	if cfg.BaseURL == "" {
+		scheme := "http"
+		if cfg.TLSCertFile != "" {
+			scheme = "https"
 		}
+		cfg.BaseURL = fmt.Sprintf("%s://localhost:%d", scheme, cfg.Port)
 	}
+	errs = append(errs, cfg.validate(&raw)...)
 
-	dataDir := os.Getenv("DATA_DIR")
-	if dataDir == "" {
-		dataDir = "data"
+	if len(errs) > 0 {
+		return nil, validationError(errs)
 	}
 
-	csrfKey, err := getCSRFKey(dataDir)
+	csrfKey, err := getCSRFKey(cfg.DataDir, raw.csrfKey)
 	if err != nil {
 		return nil, err
 	}
+	cfg.CSRFKey = csrfKey
 
-	isProd := os.Getenv("ENV") == "production"
-
-	return &Config{
-		Port:    port,
-		DataDir: dataDir,
-		CSRFKey: csrfKey,
-		IsProd:  isProd,
-	}, nil
+	return cfg, nil
 }
 
-func getCSRFKey(dataDir string) ([]byte, error) {
-	if key := os.Getenv("CSRF_KEY"); key != "" {
-		return base64.StdEncoding.DecodeString(key)
+func getCSRFKey(dataDir, configured string) ([]byte, error) {
+	if configured != "" {
+		return base64.StdEncoding.DecodeString(configured)
 	}
 
 	// Try to load existing key from file
@@ -0,0 +1,152 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Redacted replaces secret values in printed configuration
+const Redacted = "REDACTED"
+
+// loadFile applies the values in a YAML config file. Unknown keys and values
+// of the wrong type are errors, reported with their line number.
+func loadFile(path string, settings []setting) []string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return []string{fmt.Sprintf("failed to read config file: %v", err)}
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return []string{fmt.Sprintf("%s: %v", path, err)}
+	}
+	if len(doc.Content) == 0 {
+		return nil // empty file
+	}
+
+	byKey := make(map[string]setting, len(settings))
+	for _, s := range settings {
+		byKey[s.key] = s
+	}
+
+	var errs []string
+	var walk func(node *yaml.Node, prefix string)
+	walk = func(node *yaml.Node, prefix string) {
+		if node.Tag == "!!null" {
+			return // empty section
+		}
+		if node.Kind != yaml.MappingNode {
+			errs = append(errs, fmt.Sprintf("%s:%d: %s must be a mapping", path, node.Line, strings.TrimSuffix(prefix, ".")))
+			return
+		}
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			keyNode, valueNode := node.Content[i], node.Content[i+1]
+			key := prefix + keyNode.Value
+
+			if s, ok := byKey[key]; ok {
+				if valueNode.Kind != yaml.ScalarNode {
+					errs = append(errs, fmt.Sprintf("%s:%d: %s must be a single value", path, valueNode.Line, key))
+					continue
+				}
+				if valueNode.Tag == "!!null" {
+					continue
+				}
+				if err := parseValue(s.value, valueNode.Value); err != nil {
+					errs = append(errs, fmt.Sprintf("%s:%d: %s: %v", path, valueNode.Line, key, err))
+				}
+				continue
+			}
+
+			if isSection(key, settings) {
+				walk(valueNode, key+".")
+				continue
+			}
+
+			errs = append(errs, fmt.Sprintf("%s:%d: unknown setting %q", path, keyNode.Line, key))
+		}
+	}
+	walk(doc.Content[0], "")
+
+	return errs
+}
+
+// isSection reports whether key is the parent of any setting
+func isSection(key string, settings []setting) bool {
+	for _, s := range settings {
+		if strings.HasPrefix(s.key, key+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Print writes c as a YAML config file, each value preceded by its description
+// and environment variable. Secrets are replaced with REDACTED.
+func (c *Config) Print(w io.Writer) error {
+	raw := rawValues{
+		environment: "development",
+		archiveDays: int(c.ArchiveRetention / (24 * time.Hour)),
+		csrfKey:     base64.StdEncoding.EncodeToString(c.CSRFKey),
+	}
+	if c.IsProd {
+		raw.environment = "production"
+	}
+	if len(c.CSRFKey) == 0 {
+		raw.csrfKey = ""
+	}
+
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	sections := make(map[string]*yaml.Node)
+
+	for _, s := range c.settings(&raw) {
+		parent, name := root, s.key
+		if i := strings.IndexByte(s.key, '.'); i >= 0 {
+			section := s.key[:i]
+			name = s.key[i+1:]
+
+			parent = sections[section]
+			if parent == nil {
+				parent = &yaml.Node{Kind: yaml.MappingNode}
+				sections[section] = parent
+				root.Content = append(root.Content,
+					&yaml.Node{Kind: yaml.ScalarNode, Value: section},
+					parent,
+				)
+			}
+		}
+
+		value := formatValue(s.value)
+		if s.secret && value != "" {
+			value = Redacted
+		}
+
+		valueNode := &yaml.Node{Kind: yaml.ScalarNode, Value: value, Tag: yamlTag(s.value)}
+		parent.Content = append(parent.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Value: name, HeadComment: fmt.Sprintf("%s (%s)", s.help, s.env)},
+			valueNode,
+		)
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(root); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// yamlTag makes strings print quoted when they would otherwise read as
+// another type; numbers and durations are left plain
+func yamlTag(v any) string {
+	if _, ok := v.(*string); ok {
+		return "!!str"
+	}
+	return ""
+}
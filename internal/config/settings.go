@@ -0,0 +1,502 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"driving-hours/internal/i18n"
+)
+
+// setting describes one configuration value: its dotted key in the config
+// file, the environment variable that overrides it and the field it sets
+type setting struct {
+	key    string
+	env    string
+	value  any // pointer to the destination
+	help   string
+	secret bool
+}
+
+// rawValues holds settings whose file and environment form differs from the
+// field they end up in
+type rawValues struct {
+	environment string
+	archiveDays int
+	csrfKey     string
+}
+
+// settings lists every configuration value in the order it is documented
+func (c *Config) settings(raw *rawValues) []setting {
+	return []setting{
+		{key: "environment", env: "ENV", value: &raw.environment,
+			help: "development or production (secure cookies)"},
+		{key: "data_dir", env: "DATA_DIR", value: &c.DataDir,
+			help: "Directory for JSON storage"},
+		{key: "csrf_key", env: "CSRF_KEY", value: &raw.csrfKey, secret: true,
+			help: "Base64-encoded 32-byte CSRF key (generated and saved in data_dir when empty)"},
+		{key: "encryption_key", env: "ENCRYPTION_KEY", value: &c.EncryptionKey, secret: true,
+			help: "Base64-encoded 32-byte key that encrypts the files in data_dir (empty stores them in plain text)"},
+		{key: "encryption_key_file", env: "ENCRYPTION_KEY_FILE", value: &c.EncryptionKeyFile,
+			help: "File holding encryption_key, kept outside data_dir"},
+		{key: "timezone", env: "TIMEZONE", value: &c.Timezone,
+			help: "Default timezone for dates and \"today\", e.g. America/Chicago (Local uses the server's)"},
+		{key: "default_locale", env: "DEFAULT_LOCALE", value: &c.DefaultLocale,
+			help: "Interface language when neither the user nor the browser picks an available one: en or es"},
+		{key: "dev_mode", env: "DEV_MODE", value: &c.DevMode,
+			help: "Read templates and static files from web_dir instead of the binary"},
+		{key: "web_dir", env: "WEB_DIR", value: &c.WebDir,
+			help: "Directory holding templates/ and static/ in dev mode"},
+
+		{key: "server.port", env: "PORT", value: &c.Port,
+			help: "Port to listen on"},
+		{key: "server.base_url", env: "BASE_URL", value: &c.BaseURL,
+			help: "Public address of the site for links in emails (defaults to http://localhost:port)"},
+		{key: "server.read_timeout", env: "READ_TIMEOUT", value: &c.ReadTimeout,
+			help: "Maximum time to read a request"},
+		{key: "server.write_timeout", env: "WRITE_TIMEOUT", value: &c.WriteTimeout,
+			help: "Maximum time to write a response"},
+		{key: "server.idle_timeout", env: "IDLE_TIMEOUT", value: &c.IdleTimeout,
+			help: "How long keep-alive connections stay open"},
+		{key: "server.shutdown_timeout", env: "SHUTDOWN_TIMEOUT", value: &c.ShutdownTimeout,
+			help: "How long to wait for in-flight requests on SIGINT/SIGTERM"},
+
+		{key: "tls.cert_file", env: "TLS_CERT_FILE", value: &c.TLSCertFile,
+			help: "PEM certificate chain; together with key_file enables HTTPS on server.port"},
+		{key: "tls.key_file", env: "TLS_KEY_FILE", value: &c.TLSKeyFile,
+			help: "PEM private key for cert_file"},
+		{key: "tls.redirect_addr", env: "TLS_REDIRECT_ADDR", value: &c.TLSRedirectAddr,
+			help: "Plain HTTP address such as :80 that redirects to HTTPS (empty disables)"},
+		{key: "tls.watch_interval", env: "TLS_WATCH_INTERVAL", value: &c.TLSWatchInterval,
+			help: "How often to check the certificate files for changes (0 disables; SIGHUP always reloads)"},
+
+		{key: "sessions.duration", env: "SESSION_DURATION", value: &c.SessionDuration,
+			help: "How long a login lasts without being used; each request extends it"},
+		{key: "sessions.max_lifetime", env: "SESSION_MAX_LIFETIME", value: &c.SessionMaxLifetime,
+			help: "Longest a login lasts however often it is used (0 means no limit)"},
+		{key: "sessions.cleanup_interval", env: "SESSION_CLEANUP_INTERVAL", value: &c.SessionCleanupInterval,
+			help: "How often expired sessions are removed (0 disables)"},
+
+		{key: "argon2.memory_kib", env: "ARGON2_MEMORY_KIB", value: &c.Argon2Memory,
+			help: "Argon2id memory cost in KiB for new password hashes"},
+		{key: "argon2.iterations", env: "ARGON2_ITERATIONS", value: &c.Argon2Iterations,
+			help: "Argon2id iterations for new password hashes"},
+		{key: "argon2.parallelism", env: "ARGON2_PARALLELISM", value: &c.Argon2Parallelism,
+			help: "Argon2id parallelism for new password hashes"},
+
+		{key: "requirements.day_hours", env: "DEFAULT_DAY_HOURS", value: &c.DefaultDayHours,
+			help: "Required day hours suggested for new drivers"},
+		{key: "requirements.night_hours", env: "DEFAULT_NIGHT_HOURS", value: &c.DefaultNightHours,
+			help: "Required night hours suggested for new drivers"},
+
+		{key: "archive.retention_days", env: "ARCHIVE_RETENTION_DAYS", value: &raw.archiveDays,
+			help: "Days a user must stay archived before they can be purged"},
+
+		{key: "backup.dir", env: "BACKUP_DIR", value: &c.BackupDir,
+			help: "Directory for backup archives (defaults to data_dir/backups)"},
+		{key: "backup.keep", env: "BACKUP_KEEP", value: &c.BackupKeep,
+			help: "Number of backup archives to keep (0 keeps all)"},
+		{key: "backup.interval", env: "BACKUP_INTERVAL", value: &c.BackupInterval,
+			help: "How often to take a scheduled backup (0 disables)"},
+
+		{key: "mail.transport", env: "MAIL_TRANSPORT", value: &c.MailTransport,
+			help: "smtp, or file to write emails to mail.dir instead of sending them"},
+		{key: "mail.from", env: "MAIL_FROM", value: &c.MailFrom,
+			help: "Sender address, e.g. \"Driving Hours <noreply@example.com>\""},
+		{key: "mail.dir", env: "MAIL_DIR", value: &c.MailDir,
+			help: "Directory the file transport writes .eml files to, outside data_dir (defaults to mail next to data_dir)"},
+		{key: "mail.flush_interval", env: "MAIL_FLUSH_INTERVAL", value: &c.MailFlushInterval,
+			help: "How often undelivered emails are retried (0 disables retries)"},
+		{key: "mail.retry_delay", env: "MAIL_RETRY_DELAY", value: &c.MailRetryDelay,
+			help: "Wait after a failed send; doubles after each further failure, up to a day"},
+		{key: "mail.max_attempts", env: "MAIL_MAX_ATTEMPTS", value: &c.MailMaxAttempts,
+			help: "Attempts before an email is abandoned"},
+		{key: "mail.smtp_host", env: "SMTP_HOST", value: &c.SMTPHost,
+			help: "SMTP server host name"},
+		{key: "mail.smtp_port", env: "SMTP_PORT", value: &c.SMTPPort,
+			help: "SMTP server port (587 for starttls, 465 for tls)"},
+		{key: "mail.smtp_username", env: "SMTP_USERNAME", value: &c.SMTPUsername,
+			help: "SMTP login (empty skips authentication)"},
+		{key: "mail.smtp_password", env: "SMTP_PASSWORD", value: &c.SMTPPassword, secret: true,
+			help: "SMTP password"},
+		{key: "mail.smtp_tls", env: "SMTP_TLS", value: &c.SMTPTLS,
+			help: "starttls, tls (implicit, port 465) or none (local relays only)"},
+
+		{key: "reminders.inactive_days", env: "REMINDER_INACTIVE_DAYS", value: &c.ReminderInactiveDays,
+			help: "Days without logged hours before a driver is reminded and listed in the digest"},
+		{key: "reminders.interval", env: "REMINDER_INTERVAL", value: &c.ReminderInterval,
+			help: "Least time between two reminders to the same driver (0 disables reminders)"},
+		{key: "reminders.digest_interval", env: "REMINDER_DIGEST_INTERVAL", value: &c.ReminderDigestInterval,
+			help: "Least time between two stalled-driver digests to the admin (0 disables the digest)"},
+		{key: "reminders.check_interval", env: "REMINDER_CHECK_INTERVAL", value: &c.ReminderCheckInterval,
+			help: "How often to look for stalled drivers (0 disables reminders and the digest)"},
+
+		{key: "achievements.email_driver", env: "ACHIEVEMENT_EMAIL_DRIVER", value: &c.AchievementEmailDriver,
+			help: "Email drivers when they earn an achievement"},
+		{key: "achievements.email_supervisor", env: "ACHIEVEMENT_EMAIL_SUPERVISOR", value: &c.AchievementEmailSupervisor,
+			help: "Email a driver's supervisor, if one is set, when the driver earns an achievement"},
+
+		{key: "webhooks.timeout", env: "WEBHOOK_TIMEOUT", value: &c.WebhookTimeout,
+			help: "Time limit for each webhook request"},
+		{key: "webhooks.max_attempts", env: "WEBHOOK_MAX_ATTEMPTS", value: &c.WebhookMaxAttempts,
+			help: "Attempts before a webhook delivery is abandoned"},
+		{key: "webhooks.retry_delay", env: "WEBHOOK_RETRY_DELAY", value: &c.WebhookRetryDelay,
+			help: "Wait after the first failed delivery; doubles after each further failure, up to a day"},
+		{key: "webhooks.flush_interval", env: "WEBHOOK_FLUSH_INTERVAL", value: &c.WebhookFlushInterval,
+			help: "How often failed deliveries are retried (0 disables retries)"},
+		{key: "webhooks.retention", env: "WEBHOOK_RETENTION", value: &c.WebhookRetention,
+			help: "How long finished deliveries stay in the delivery log (0 keeps them forever)"},
+
+		{key: "security.hsts_max_age", env: "HSTS_MAX_AGE", value: &c.HSTSMaxAge,
+			help: "Strict-Transport-Security max-age, sent only in production"},
+		{key: "security.csp_report_only", env: "CSP_REPORT_ONLY", value: &c.CSPReportOnly,
+			help: "Report Content-Security-Policy violations instead of blocking them"},
+
+		{key: "metrics.token", env: "METRICS_TOKEN", value: &c.MetricsToken, secret: true,
+			help: "Bearer token required to read /metrics"},
+		{key: "metrics.addr", env: "METRICS_ADDR", value: &c.MetricsAddr,
+			help: "Serve /metrics on a separate address such as 127.0.0.1:9090"},
+
+		{key: "log.format", env: "LOG_FORMAT", value: &c.LogFormat,
+			help: "text or json"},
+		{key: "log.level", env: "LOG_LEVEL", value: &c.LogLevel,
+			help: "debug, info, warn or error"},
+	}
+}
+
+// loadEnv applies environment variable overrides
+func loadEnv(settings []setting) []string {
+	var errs []string
+	for _, s := range settings {
+		v, ok := os.LookupEnv(s.env)
+		if !ok || v == "" {
+			continue
+		}
+		if err := parseValue(s.value, v); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", s.env, err))
+		}
+	}
+	return errs
+}
+
+// parseValue parses text into the value pointed to by dst
+func parseValue(dst any, text string) error {
+	text = strings.TrimSpace(text)
+
+	switch p := dst.(type) {
+	case *string:
+		*p = text
+	case *bool:
+		v, err := strconv.ParseBool(text)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", text)
+		}
+		*p = v
+	case *int:
+		v, err := strconv.Atoi(text)
+		if err != nil {
+			return fmt.Errorf("%q is not a whole number", text)
+		}
+		*p = v
+	case *uint32:
+		v, err := strconv.ParseUint(text, 10, 32)
+		if err != nil {
+			return fmt.Errorf("%q is not a whole number between 0 and %d", text, uint32(1<<32-1))
+		}
+		*p = uint32(v)
+	case *uint8:
+		v, err := strconv.ParseUint(text, 10, 8)
+		if err != nil {
+			return fmt.Errorf("%q is not a whole number between 0 and 255", text)
+		}
+		*p = uint8(v)
+	case *float64:
+		v, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", text)
+		}
+		*p = v
+	case *time.Duration:
+		v, err := time.ParseDuration(text)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration such as 30s, 15m or 24h", text)
+		}
+		*p = v
+	default:
+		return fmt.Errorf("unsupported setting type %T", dst)
+	}
+	return nil
+}
+
+// formatValue renders the value pointed to by src the way parseValue reads it
+func formatValue(src any) string {
+	switch p := src.(type) {
+	case *string:
+		return *p
+	case *bool:
+		return strconv.FormatBool(*p)
+	case *int:
+		return strconv.Itoa(*p)
+	case *uint32:
+		return strconv.FormatUint(uint64(*p), 10)
+	case *uint8:
+		return strconv.FormatUint(uint64(*p), 10)
+	case *float64:
+		return strconv.FormatFloat(*p, 'f', -1, 64)
+	case *time.Duration:
+		return formatDuration(*p)
+	}
+	return fmt.Sprint(src)
+}
+
+// formatDuration drops the zero units time.Duration.String adds, so 24h
+// prints as "24h" rather than "24h0m0s"
+func formatDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+// validate checks the combined configuration and returns one message per problem
+func (c *Config) validate(raw *rawValues) []string {
+	var errs []string
+	fail := func(key, format string, args ...any) {
+		errs = append(errs, key+": "+fmt.Sprintf(format, args...))
+	}
+
+	if raw.environment != "development" && raw.environment != "production" {
+		fail("environment", "must be development or production, got %q", raw.environment)
+	}
+	if strings.TrimSpace(c.DataDir) == "" {
+		fail("data_dir", "must not be empty")
+	}
+	if raw.csrfKey != "" {
+		key, err := base64.StdEncoding.DecodeString(raw.csrfKey)
+		if err != nil || len(key) != 32 {
+			fail("csrf_key", "must be 32 bytes encoded as standard base64")
+		}
+	}
+
+	if c.EncryptionKey != "" {
+		if c.EncryptionKeyFile != "" {
+			fail("encryption_key", "must not be set together with encryption_key_file")
+		}
+		key, err := base64.StdEncoding.DecodeString(c.EncryptionKey)
+		if err != nil || len(key) != 32 {
+			fail("encryption_key", "must be 32 bytes encoded as standard base64")
+		}
+	}
+	if c.EncryptionKeyFile != "" {
+		if _, err := os.Stat(c.EncryptionKeyFile); err != nil {
+			fail("encryption_key_file", "%v", err)
+		}
+	}
+
+	if c.DevMode {
+		for _, dir := range []string{"templates", "static"} {
+			if info, err := os.Stat(filepath.Join(c.WebDir, dir)); err != nil || !info.IsDir() {
+				fail("web_dir", "%s has no %s directory (needed by dev_mode)", c.WebDir, dir)
+			}
+		}
+	}
+
+	if c.Port < 1 || c.Port > 65535 {
+		fail("server.port", "must be between 1 and 65535, got %d", c.Port)
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		fail("tls", "cert_file and key_file must be set together")
+	}
+	for key, name := range map[string]string{"tls.cert_file": c.TLSCertFile, "tls.key_file": c.TLSKeyFile} {
+		if name == "" {
+			continue
+		}
+		if _, err := os.Stat(name); err != nil {
+			fail(key, "cannot read %s: %v", name, err)
+		}
+	}
+	if c.TLSRedirectAddr != "" {
+		if c.TLSCertFile == "" {
+			fail("tls.redirect_addr", "requires cert_file and key_file")
+		}
+		if _, _, err := net.SplitHostPort(c.TLSRedirectAddr); err != nil {
+			fail("tls.redirect_addr", "must be host:port, got %q", c.TLSRedirectAddr)
+		}
+	}
+	if c.TLSWatchInterval < 0 {
+		fail("tls.watch_interval", "must not be negative")
+	}
+
+	for _, d := range []struct {
+		key   string
+		value time.Duration
+	}{
+		{"server.read_timeout", c.ReadTimeout},
+		{"server.write_timeout", c.WriteTimeout},
+		{"server.idle_timeout", c.IdleTimeout},
+		{"server.shutdown_timeout", c.ShutdownTimeout},
+		{"sessions.duration", c.SessionDuration},
+	} {
+		if d.value <= 0 {
+			fail(d.key, "must be greater than zero")
+		}
+	}
+	if c.SessionMaxLifetime < 0 {
+		fail("sessions.max_lifetime", "must not be negative")
+	}
+	if c.SessionCleanupInterval < 0 {
+		fail("sessions.cleanup_interval", "must not be negative")
+	}
+
+	if c.Argon2Iterations < 1 {
+		fail("argon2.iterations", "must be at least 1")
+	}
+	if c.Argon2Parallelism < 1 {
+		fail("argon2.parallelism", "must be at least 1")
+	}
+	if c.Argon2Memory < 8*uint32(c.Argon2Parallelism) {
+		fail("argon2.memory_kib", "must be at least 8 KiB per unit of parallelism")
+	}
+
+	if _, err := time.LoadLocation(c.Timezone); err != nil {
+		fail("timezone", "unknown timezone %q", c.Timezone)
+	}
+	if !i18n.Supported(c.DefaultLocale) {
+		fail("default_locale", "no catalog for %q", c.DefaultLocale)
+	}
+
+	if c.DefaultDayHours < 0 {
+		fail("requirements.day_hours", "must not be negative")
+	}
+	if c.DefaultNightHours < 0 {
+		fail("requirements.night_hours", "must not be negative")
+	}
+
+	if raw.archiveDays < 0 {
+		fail("archive.retention_days", "must not be negative")
+	}
+	if c.BackupKeep < 0 {
+		fail("backup.keep", "must not be negative")
+	}
+	if c.BackupInterval < 0 {
+		fail("backup.interval", "must not be negative")
+	}
+
+	if c.BaseURL != "" {
+		if u, err := url.Parse(c.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail("server.base_url", "must be an absolute http or https URL, got %q", c.BaseURL)
+		}
+	}
+
+	switch c.MailTransport {
+	case "file":
+		if isWithin(c.MailDir, c.DataDir) {
+			fail("mail.dir", "must be outside data_dir, since emails are written unencrypted")
+		}
+	case "smtp":
+		if c.SMTPHost == "" {
+			fail("mail.smtp_host", "is required for the smtp transport")
+		}
+	default:
+		fail("mail.transport", "must be smtp or file, got %q", c.MailTransport)
+	}
+	if _, err := mail.ParseAddress(c.MailFrom); err != nil {
+		fail("mail.from", "invalid address %q", c.MailFrom)
+	}
+	if c.MailFlushInterval < 0 {
+		fail("mail.flush_interval", "must not be negative")
+	}
+	if c.MailRetryDelay <= 0 {
+		fail("mail.retry_delay", "must be greater than zero")
+	}
+	if c.MailMaxAttempts < 1 {
+		fail("mail.max_attempts", "must be at least 1")
+	}
+	if c.SMTPPort < 1 || c.SMTPPort > 65535 {
+		fail("mail.smtp_port", "must be between 1 and 65535, got %d", c.SMTPPort)
+	}
+	if c.SMTPTLS != "starttls" && c.SMTPTLS != "tls" && c.SMTPTLS != "none" {
+		fail("mail.smtp_tls", "must be starttls, tls or none, got %q", c.SMTPTLS)
+	}
+
+	if c.ReminderInactiveDays < 1 {
+		fail("reminders.inactive_days", "must be at least 1")
+	}
+	if c.ReminderInterval < 0 {
+		fail("reminders.interval", "must not be negative")
+	}
+	if c.ReminderDigestInterval < 0 {
+		fail("reminders.digest_interval", "must not be negative")
+	}
+	if c.ReminderCheckInterval < 0 {
+		fail("reminders.check_interval", "must not be negative")
+	}
+
+	if c.WebhookTimeout <= 0 {
+		fail("webhooks.timeout", "must be greater than zero")
+	}
+	if c.WebhookMaxAttempts < 1 {
+		fail("webhooks.max_attempts", "must be at least 1")
+	}
+	if c.WebhookRetryDelay <= 0 {
+		fail("webhooks.retry_delay", "must be greater than zero")
+	}
+	if c.WebhookFlushInterval < 0 {
+		fail("webhooks.flush_interval", "must not be negative")
+	}
+	if c.WebhookRetention < 0 {
+		fail("webhooks.retention", "must not be negative")
+	}
+
+	if c.HSTSMaxAge < 0 {
+		fail("security.hsts_max_age", "must not be negative")
+	}
+
+	if c.MetricsAddr != "" {
+		if _, _, err := net.SplitHostPort(c.MetricsAddr); err != nil {
+			fail("metrics.addr", "must be host:port, got %q", c.MetricsAddr)
+		}
+	}
+
+	format := strings.ToLower(c.LogFormat)
+	if format != "text" && format != "json" {
+		fail("log.format", "must be text or json, got %q", c.LogFormat)
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		fail("log.level", "must be debug, info, warn or error, got %q", c.LogLevel)
+	}
+
+	return errs
+}
+
+// validationError joins every problem into one error so they can all be fixed at once
+// isWithin reports whether path is dir or inside it
+func isWithin(path, dir string) bool {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(absDir, absPath)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func validationError(errs []string) error {
+	return fmt.Errorf("invalid configuration:\n  - %s", strings.Join(errs, "\n  - "))
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"encoding/csv"
 	"fmt"
+	"io"
 	"net/http"
 	"sort"
 	"strconv"
@@ -12,23 +13,45 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 
+	"driving-hours/internal/achievements"
 	"driving-hours/internal/auth"
+	"driving-hours/internal/i18n"
 	"driving-hours/internal/models"
 	"driving-hours/internal/storage"
 	"driving-hours/internal/templates"
+	"driving-hours/internal/utils"
+	"driving-hours/internal/webhooks"
 )
 
+// AdminOptions holds the configurable policies of the admin pages
+type AdminOptions struct {
+	// ArchiveRetention is how long a user must stay archived before they can be purged
+	ArchiveRetention time.Duration
+
+	// DefaultDayHours and DefaultNightHours prefill the new user form
+	DefaultDayHours   float64
+	DefaultNightHours float64
+
+	// Achievements announces milestones reached when an admin edits hours
+	Achievements *achievements.Announcer
+
+	// Webhooks is told about new users and changes to hours
+	Webhooks *webhooks.Dispatcher
+}
+
 type AdminHandler struct {
 	storage  storage.Storage
 	sessions *auth.SessionManager
 	renderer *templates.Renderer
+	options  AdminOptions
 }
 
-func NewAdminHandler(s storage.Storage, sm *auth.SessionManager, r *templates.Renderer) *AdminHandler {
+func NewAdminHandler(s storage.Storage, sm *auth.SessionManager, r *templates.Renderer, options AdminOptions) *AdminHandler {
 	return &AdminHandler{
 		storage:  s,
 		sessions: sm,
 		renderer: r,
+		options:  options,
 	}
 }
 
@@ -37,7 +60,7 @@ func (h *AdminHandler) Dashboard(w http.ResponseWriter, r *http.Request) {
 
 	drivers, err := h.storage.GetDrivers()
 	if err != nil {
-		http.Error(w, "Failed to load drivers", http.StatusInternalServerError)
+		serverError(w, r, "Failed to load drivers", err)
 		return
 	}
 
@@ -53,17 +76,28 @@ func (h *AdminHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
 
 	users, err := h.storage.GetAllUsers()
 	if err != nil {
-		http.Error(w, "Failed to load users", http.StatusInternalServerError)
+		serverError(w, r, "Failed to load users", err)
 		return
 	}
 
 	h.renderer.Render(w, r, "admin/users.html", templates.Data{
 		"Title": "Manage Users",
 		"User":  user,
-		"Users": users,
+		"Users": activeUsers(users),
 	})
 }
 
+// activeUsers filters out archived users
+func activeUsers(users []*models.User) []*models.User {
+	var active []*models.User
+	for _, u := range users {
+		if !u.IsArchived() {
+			active = append(active, u)
+		}
+	}
+	return active
+}
+
 func (h *AdminHandler) NewUserForm(w http.ResponseWriter, r *http.Request) {
 	user := auth.GetUser(r)
 
@@ -72,19 +106,28 @@ func (h *AdminHandler) NewUserForm(w http.ResponseWriter, r *http.Request) {
 		"User":             user,
 		"IsNew":            true,
 		"CanChangePassword": true,
-		"EditUser":         &models.User{Role: models.RoleDriver},
+		"EditUser": &models.User{
+			Role:               models.RoleDriver,
+			RequiredDayHours:   h.options.DefaultDayHours,
+			RequiredNightHours: h.options.DefaultNightHours,
+		},
 	})
 }
 
 func (h *AdminHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 	user := auth.GetUser(r)
 
-	email := r.FormValue("email")
+	email := models.NormalizeEmail(r.FormValue("email"))
 	name := r.FormValue("name")
 	password := r.FormValue("password")
 	roleStr := r.FormValue("role")
 	dayHoursStr := r.FormValue("required_day_hours")
 	nightHoursStr := r.FormValue("required_night_hours")
+	group := strings.TrimSpace(r.FormValue("group"))
+	instructor := strings.TrimSpace(r.FormValue("instructor"))
+	supervisorEmail := strings.TrimSpace(r.FormValue("supervisor_email"))
+	timezone := strings.TrimSpace(r.FormValue("timezone"))
+	locale := r.FormValue("locale")
 
 	// Parse and validate role
 	role := models.Role(roleStr)
@@ -103,6 +146,15 @@ func (h *AdminHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 	if password == "" {
 		errors = append(errors, "Password is required")
 	}
+	if ok, msg := utils.ValidateTimezone(timezone); !ok {
+		errors = append(errors, msg)
+	}
+	if ok, msg := utils.ValidateLocale(locale); !ok {
+		errors = append(errors, msg)
+	}
+	if supervisorEmail != "" && !utils.ValidateEmail(supervisorEmail) {
+		errors = append(errors, "Invalid supervisor email")
+	}
 
 	dayHours, _ := strconv.ParseFloat(dayHoursStr, 64)
 	nightHours, _ := strconv.ParseFloat(nightHoursStr, 64)
@@ -120,13 +172,19 @@ func (h *AdminHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 				Role:               role,
 				RequiredDayHours:   dayHours,
 				RequiredNightHours: nightHours,
+				Group:              group,
+				Instructor:         instructor,
+				SupervisorEmail:    supervisorEmail,
+				Timezone:           timezone,
+				Locale:             locale,
 			},
 		})
 		return
 	}
 
 	// Check if email already exists
-	existing, _ := h.storage.GetUserByEmail(email)
+	existing, err := h.storage.GetUserByEmail(email)
+	logError(r, "Failed to look up email", err)
 	if existing != nil {
 		h.renderer.Render(w, r, "admin/user_form.html", templates.Data{
 			"Title":             "Create User",
@@ -140,6 +198,11 @@ func (h *AdminHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 				Role:               role,
 				RequiredDayHours:   dayHours,
 				RequiredNightHours: nightHours,
+				Group:              group,
+				Instructor:         instructor,
+				SupervisorEmail:    supervisorEmail,
+				Timezone:           timezone,
+				Locale:             locale,
 			},
 		})
 		return
@@ -147,7 +210,7 @@ func (h *AdminHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 
 	hash, err := auth.HashPassword(password)
 	if err != nil {
-		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+		serverError(w, r, "Failed to hash password", err)
 		return
 	}
 
@@ -160,16 +223,33 @@ func (h *AdminHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 		Role:               role,
 		RequiredDayHours:   dayHours,
 		RequiredNightHours: nightHours,
+		Group:              group,
+		Instructor:         instructor,
+		SupervisorEmail:    supervisorEmail,
+		Timezone:           timezone,
+		Locale:             locale,
 		CreatedAt:          now,
 		UpdatedAt:          now,
-		DrivingLog:         make(models.DrivingLog),
 	}
 
-	if err := h.storage.SaveUser(newUser); err != nil {
-		http.Error(w, "Failed to create user", http.StatusInternalServerError)
+	// The check above can race with another save; storage has the last word
+	if err := h.storage.SaveUser(newUser); isEmailTaken(err) {
+		h.renderer.Render(w, r, "admin/user_form.html", templates.Data{
+			"Title":             "Create User",
+			"User":              user,
+			"IsNew":             true,
+			"CanChangePassword": true,
+			"Errors":            []string{"Email already in use"},
+			"EditUser":          newUser,
+		})
+		return
+	} else if err != nil {
+		serverError(w, r, "Failed to create user", err)
 		return
 	}
 
+	logError(r, "Failed to publish webhook", h.options.Webhooks.PublishUserCreated(r.Context(), newUser))
+
 	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
 }
 
@@ -179,14 +259,16 @@ func (h *AdminHandler) ViewDriver(w http.ResponseWriter, r *http.Request) {
 
 	driver, err := h.storage.GetUser(driverID)
 	if err != nil || driver == nil {
+		logError(r, "Failed to load user", err)
 		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
 		return
 	}
 
 	h.renderer.Render(w, r, "admin/driver_stats.html", templates.Data{
-		"Title":  driver.Name + " - Statistics",
+		"Title":  i18n.T(r.Context(), "%s - Statistics", driver.Name),
 		"User":   user,
 		"Driver": driver,
+		"Badges": achievements.Badges(driver),
 	})
 }
 
@@ -196,6 +278,7 @@ func (h *AdminHandler) EditUserForm(w http.ResponseWriter, r *http.Request) {
 
 	editUser, err := h.storage.GetUser(editUserID)
 	if err != nil || editUser == nil {
+		logError(r, "Failed to load user", err)
 		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
 		return
 	}
@@ -204,7 +287,7 @@ func (h *AdminHandler) EditUserForm(w http.ResponseWriter, r *http.Request) {
 	canChangePassword := !editUser.IsAdmin()
 
 	h.renderer.Render(w, r, "admin/user_form.html", templates.Data{
-		"Title":             "Edit " + editUser.Name,
+		"Title":             i18n.T(r.Context(), "Edit %s", editUser.Name),
 		"User":              user,
 		"IsNew":             false,
 		"CanChangePassword": canChangePassword,
@@ -218,6 +301,7 @@ func (h *AdminHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 
 	editUser, err := h.storage.GetUser(editUserID)
 	if err != nil || editUser == nil {
+		logError(r, "Failed to load user", err)
 		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
 		return
 	}
@@ -225,11 +309,16 @@ func (h *AdminHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	// Admins cannot change another admin's password
 	canChangePassword := !editUser.IsAdmin()
 
-	email := r.FormValue("email")
+	email := models.NormalizeEmail(r.FormValue("email"))
 	name := r.FormValue("name")
 	password := r.FormValue("password")
 	dayHoursStr := r.FormValue("required_day_hours")
 	nightHoursStr := r.FormValue("required_night_hours")
+	group := strings.TrimSpace(r.FormValue("group"))
+	instructor := strings.TrimSpace(r.FormValue("instructor"))
+	supervisorEmail := strings.TrimSpace(r.FormValue("supervisor_email"))
+	timezone := strings.TrimSpace(r.FormValue("timezone"))
+	locale := r.FormValue("locale")
 
 	// Validation
 	var errors []string
@@ -239,18 +328,40 @@ func (h *AdminHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	if name == "" {
 		errors = append(errors, "Name is required")
 	}
+	if ok, msg := utils.ValidateTimezone(timezone); !ok {
+		errors = append(errors, msg)
+	}
+	if ok, msg := utils.ValidateLocale(locale); !ok {
+		errors = append(errors, msg)
+	}
+	if supervisorEmail != "" && !utils.ValidateEmail(supervisorEmail) {
+		errors = append(errors, "Invalid supervisor email")
+	}
+
+	// Saving a form filled in from an older version would undo someone else's
+	// changes, so the stale version is kept until the page is reloaded
+	version := formVersion(r)
+	if version != editUser.Version {
+		errors = append(errors, changedMessage)
+	}
 
 	dayHours, _ := strconv.ParseFloat(dayHoursStr, 64)
 	nightHours, _ := strconv.ParseFloat(nightHoursStr, 64)
 
 	if len(errors) > 0 {
+		editUser.Version = version
 		editUser.Email = email
 		editUser.Name = name
 		editUser.RequiredDayHours = dayHours
 		editUser.RequiredNightHours = nightHours
+		editUser.Group = group
+		editUser.Instructor = instructor
+		editUser.SupervisorEmail = supervisorEmail
+		editUser.Timezone = timezone
+		editUser.Locale = locale
 
 		h.renderer.Render(w, r, "admin/user_form.html", templates.Data{
-			"Title":             "Edit " + editUser.Name,
+			"Title":             i18n.T(r.Context(), "Edit %s", editUser.Name),
 			"User":              user,
 			"IsNew":             false,
 			"CanChangePassword": canChangePassword,
@@ -261,10 +372,11 @@ func (h *AdminHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check if email is taken by another user
-	existing, _ := h.storage.GetUserByEmail(email)
+	existing, err := h.storage.GetUserByEmail(email)
+	logError(r, "Failed to look up email", err)
 	if existing != nil && existing.ID != editUser.ID {
 		h.renderer.Render(w, r, "admin/user_form.html", templates.Data{
-			"Title":             "Edit " + editUser.Name,
+			"Title":             i18n.T(r.Context(), "Edit %s", editUser.Name),
 			"User":              user,
 			"IsNew":             false,
 			"CanChangePassword": canChangePassword,
@@ -278,62 +390,87 @@ func (h *AdminHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	editUser.Name = name
 	editUser.RequiredDayHours = dayHours
 	editUser.RequiredNightHours = nightHours
+	editUser.Group = group
+	editUser.Instructor = instructor
+	editUser.SupervisorEmail = supervisorEmail
+	editUser.Timezone = timezone
+	editUser.Locale = locale
 
 	// Update password if provided (only for drivers, not other admins)
 	if password != "" && canChangePassword {
 		hash, err := auth.HashPassword(password)
 		if err != nil {
-			http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+			serverError(w, r, "Failed to hash password", err)
 			return
 		}
 		editUser.PasswordHash = hash
 	}
 
-	if err := h.storage.SaveUser(editUser); err != nil {
-		http.Error(w, "Failed to update user", http.StatusInternalServerError)
+	if err := h.storage.SaveUser(editUser); isConflict(err) {
+		h.renderer.Render(w, r, "admin/user_form.html", templates.Data{
+			"Title":             i18n.T(r.Context(), "Edit %s", editUser.Name),
+			"User":              user,
+			"IsNew":             false,
+			"CanChangePassword": canChangePassword,
+			"Errors":            []string{changedMessage},
+			"EditUser":          editUser,
+		})
+		return
+	} else if isEmailTaken(err) {
+		h.renderer.Render(w, r, "admin/user_form.html", templates.Data{
+			"Title":             i18n.T(r.Context(), "Edit %s", editUser.Name),
+			"User":              user,
+			"IsNew":             false,
+			"CanChangePassword": canChangePassword,
+			"Errors":            []string{"Email already in use"},
+			"EditUser":          editUser,
+		})
+		return
+	} else if err != nil {
+		serverError(w, r, "Failed to update user", err)
 		return
 	}
 
 	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
 }
 
-func (h *AdminHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+func (h *AdminHandler) ArchiveUser(w http.ResponseWriter, r *http.Request) {
 	user := auth.GetUser(r)
-	deleteUserID := chi.URLParam(r, "id")
-	deletingSelf := deleteUserID == user.ID
+	archiveUserID := chi.URLParam(r, "id")
+	archivingSelf := archiveUserID == user.ID
 
-	deleteUser, err := h.storage.GetUser(deleteUserID)
-	if err != nil || deleteUser == nil {
+	archiveUser, err := h.storage.GetUser(archiveUserID)
+	if err != nil || archiveUser == nil {
+		logError(r, "Failed to load user", err)
 		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
 		return
 	}
 
-	// Prevent deleting self if last admin
-	if deletingSelf && user.IsAdmin() {
-		users, err := h.storage.GetAllUsers()
+	// Prevent archiving self if last admin
+	if archivingSelf && user.IsAdmin() {
+		adminCount, err := h.activeAdminCount()
 		if err != nil {
-			http.Error(w, "Failed to check admin count", http.StatusInternalServerError)
+			serverError(w, r, "Failed to check admin count", err)
 			return
 		}
-		adminCount := 0
-		for _, u := range users {
-			if u.IsAdmin() {
-				adminCount++
-			}
-		}
 		if adminCount <= 1 {
 			http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
 			return
 		}
 	}
 
-	if err := h.storage.DeleteUser(deleteUserID); err != nil {
-		http.Error(w, "Failed to delete user", http.StatusInternalServerError)
+	now := time.Now()
+	_, err = storage.UpdateUser(h.storage, archiveUserID, func(u *models.User) error {
+		u.ArchivedAt = &now
+		return nil
+	})
+	if err != nil {
+		serverError(w, r, "Failed to archive user", err)
 		return
 	}
 
-	// Log out if deleting self
-	if deletingSelf {
+	// Log out if archiving self
+	if archivingSelf {
 		_ = h.sessions.DestroySession(w, r)
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
@@ -342,20 +479,139 @@ func (h *AdminHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
 }
 
+// activeAdminCount counts the admins who can sign in: the admin in
+// admin.json, who can't be archived, and the unarchived admins among the users
+func (h *AdminHandler) activeAdminCount() (int, error) {
+	admin, err := h.storage.GetAdmin()
+	if err != nil {
+		return 0, err
+	}
+	users, err := h.storage.GetAllUsers()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	if admin != nil {
+		count++
+	}
+	for _, u := range users {
+		if u.IsAdmin() && !u.IsArchived() && (admin == nil || u.ID != admin.ID) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// ArchivedUser pairs an archived user with the time they become eligible for purging
+type ArchivedUser struct {
+	*models.User
+	PurgeAfter time.Time
+	CanPurge   bool
+}
+
+func (h *AdminHandler) ArchivedUsers(w http.ResponseWriter, r *http.Request) {
+	h.renderArchived(w, r, "", "")
+}
+
+func (h *AdminHandler) renderArchived(w http.ResponseWriter, r *http.Request, errMsg, success string) {
+	user := auth.GetUser(r)
+
+	users, err := h.storage.GetArchivedUsers()
+	if err != nil {
+		serverError(w, r, "Failed to load archived users", err)
+		return
+	}
+
+	now := time.Now()
+	archived := make([]ArchivedUser, 0, len(users))
+	for _, u := range users {
+		purgeAfter := u.ArchivedAt.Add(h.options.ArchiveRetention)
+		archived = append(archived, ArchivedUser{
+			User:       u,
+			PurgeAfter: purgeAfter,
+			CanPurge:   !now.Before(purgeAfter),
+		})
+	}
+	sort.Slice(archived, func(i, j int) bool {
+		return archived[i].ArchivedAt.After(*archived[j].ArchivedAt)
+	})
+
+	h.renderer.Render(w, r, "admin/archived.html", templates.Data{
+		"Title":         "Archived Users",
+		"User":          user,
+		"Archived":      archived,
+		"RetentionDays": int(h.options.ArchiveRetention.Hours() / 24),
+		"Error":         errMsg,
+		"Success":       success,
+	})
+}
+
+func (h *AdminHandler) RestoreUser(w http.ResponseWriter, r *http.Request) {
+	restoreUser, err := h.storage.GetUser(chi.URLParam(r, "id"))
+	if err != nil || restoreUser == nil || !restoreUser.IsArchived() {
+		logError(r, "Failed to load user", err)
+		http.Redirect(w, r, "/admin/users/archived", http.StatusSeeOther)
+		return
+	}
+
+	_, err = storage.UpdateUser(h.storage, restoreUser.ID, func(u *models.User) error {
+		u.ArchivedAt = nil
+		return nil
+	})
+	if err != nil {
+		serverError(w, r, "Failed to restore user", err)
+		return
+	}
+
+	h.renderArchived(w, r, "", i18n.T(r.Context(), "%s has been restored", restoreUser.Name))
+}
+
+func (h *AdminHandler) PurgeUser(w http.ResponseWriter, r *http.Request) {
+	purgeUser, err := h.storage.GetUser(chi.URLParam(r, "id"))
+	if err != nil || purgeUser == nil || !purgeUser.IsArchived() {
+		logError(r, "Failed to load user", err)
+		http.Redirect(w, r, "/admin/users/archived", http.StatusSeeOther)
+		return
+	}
+
+	// Only users past the retention period can be permanently deleted
+	if time.Now().Before(purgeUser.ArchivedAt.Add(h.options.ArchiveRetention)) {
+		h.renderArchived(w, r, i18n.T(r.Context(), "%s is still within the retention period", purgeUser.Name), "")
+		return
+	}
+
+	// Require the admin to type the user's email to confirm
+	if !strings.EqualFold(strings.TrimSpace(r.FormValue("confirm_email")), purgeUser.Email) {
+		h.renderArchived(w, r, "Type the user's email address to confirm permanent deletion", "")
+		return
+	}
+
+	if err := h.storage.DeleteUser(purgeUser.ID); err != nil {
+		serverError(w, r, "Failed to purge user", err)
+		return
+	}
+
+	h.renderArchived(w, r, "", i18n.T(r.Context(), "%s has been permanently deleted", purgeUser.Name))
+}
+
 func (h *AdminHandler) EditHoursForm(w http.ResponseWriter, r *http.Request) {
 	user := auth.GetUser(r)
 	driverID := chi.URLParam(r, "id")
 
 	driver, err := h.storage.GetUser(driverID)
 	if err != nil || driver == nil {
+		logError(r, "Failed to load user", err)
 		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
 		return
 	}
 
 	h.renderer.Render(w, r, "admin/driver_hours.html", templates.Data{
-		"Title":  driver.Name + " - Edit Hours",
+		"Title":  i18n.T(r.Context(), "%s - Edit Hours", driver.Name),
 		"User":   user,
 		"Driver": driver,
+		"Today":  driver.Today(),
+		"Error":  logHoursErrors[r.URL.Query().Get("error")],
 	})
 }
 
@@ -364,6 +620,7 @@ func (h *AdminHandler) UpdateHours(w http.ResponseWriter, r *http.Request) {
 
 	driver, err := h.storage.GetUser(driverID)
 	if err != nil || driver == nil {
+		logError(r, "Failed to load user", err)
 		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
 		return
 	}
@@ -375,41 +632,35 @@ func (h *AdminHandler) UpdateHours(w http.ResponseWriter, r *http.Request) {
 	nightMinutesStr := r.FormValue("night_minutes")
 	deleteEntry := r.FormValue("delete") == "1"
 
-	if date == "" {
-		http.Redirect(w, r, "/admin/users/"+driverID+"/hours", http.StatusSeeOther)
-		return
-	}
-
-	if deleteEntry {
-		delete(driver.DrivingLog, date)
-	} else {
+	var entry models.DayEntry
+	if !deleteEntry {
 		dayHours, _ := strconv.ParseFloat(dayHoursStr, 64)
 		dayMinutes, _ := strconv.ParseFloat(dayMinutesStr, 64)
 		nightHours, _ := strconv.ParseFloat(nightHoursStr, 64)
 		nightMinutes, _ := strconv.ParseFloat(nightMinutesStr, 64)
 
-		totalDayHours := dayHours + (dayMinutes / 60)
-		totalNightHours := nightHours + (nightMinutes / 60)
-
-		if driver.DrivingLog == nil {
-			driver.DrivingLog = make(models.DrivingLog)
-		}
+		entry.DayHours = dayHours + (dayMinutes / 60)
+		entry.NightHours = nightHours + (nightMinutes / 60)
+	}
 
-		if totalDayHours > 0 || totalNightHours > 0 {
-			driver.DrivingLog[date] = models.DayEntry{
-				DayHours:   totalDayHours,
-				NightHours: totalNightHours,
-			}
-		} else {
-			delete(driver.DrivingLog, date)
-		}
+	errCode := validateLogDate(driver, date, deleteEntry)
+	if errCode == "" && !deleteEntry {
+		errCode = validateLogHours(entry)
+	}
+	if errCode != "" {
+		http.Redirect(w, r, "/admin/users/"+driverID+"/hours?error="+errCode, http.StatusSeeOther)
+		return
 	}
 
-	if err := h.storage.SaveUser(driver); err != nil {
-		http.Error(w, "Failed to update hours", http.StatusInternalServerError)
+	saved, change, err := saveLogEntry(h.storage, driverID, date, entry)
+	if err != nil || saved == nil {
+		serverError(w, r, "Failed to update hours", err)
 		return
 	}
 
+	h.options.Achievements.Announce(r.Context(), saved, change.earned)
+	logError(r, "Failed to publish webhook", h.options.Webhooks.PublishLogChange(r.Context(), saved, date, change.before, change.wasMet))
+
 	http.Redirect(w, r, "/admin/users/"+driverID+"/hours", http.StatusSeeOther)
 }
 
@@ -428,6 +679,8 @@ func (h *AdminHandler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
 	name := r.FormValue("name")
 	currentPassword := r.FormValue("current_password")
 	newPassword := r.FormValue("new_password")
+	timezone := strings.TrimSpace(r.FormValue("timezone"))
+	locale := r.FormValue("locale")
 
 	var errors []string
 	var success string
@@ -435,6 +688,12 @@ func (h *AdminHandler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
 	if name == "" {
 		errors = append(errors, "Name is required")
 	}
+	if ok, msg := utils.ValidateTimezone(timezone); !ok {
+		errors = append(errors, msg)
+	}
+	if ok, msg := utils.ValidateLocale(locale); !ok {
+		errors = append(errors, msg)
+	}
 
 	// If changing password, validate current password
 	if newPassword != "" {
@@ -447,6 +706,9 @@ func (h *AdminHandler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 	}
+	if formVersion(r) != user.Version {
+		errors = append(errors, changedMessage)
+	}
 
 	if len(errors) > 0 {
 		h.renderer.Render(w, r, "admin/profile.html", templates.Data{
@@ -459,11 +721,13 @@ func (h *AdminHandler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	user.Name = name
+	user.Timezone = timezone
+	user.Locale = locale
 
 	if newPassword != "" {
 		hash, err := auth.HashPassword(newPassword)
 		if err != nil {
-			http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+			serverError(w, r, "Failed to hash password", err)
 			return
 		}
 		user.PasswordHash = hash
@@ -472,11 +736,27 @@ func (h *AdminHandler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
 		success = "Profile updated successfully"
 	}
 
-	if err := h.storage.SaveAdmin(user); err != nil {
-		http.Error(w, "Failed to update profile", http.StatusInternalServerError)
+	// Admins created from the users page are stored with the other users
+	save := h.storage.SaveUser
+	if admin, err := h.storage.GetAdmin(); err == nil && admin != nil && admin.ID == user.ID {
+		save = h.storage.SaveAdmin
+	}
+	if err := save(user); isConflict(err) {
+		h.renderer.Render(w, r, "admin/profile.html", templates.Data{
+			"Title":  "Profile",
+			"User":   user,
+			"Errors": []string{changedMessage},
+			"Name":   name,
+		})
+		return
+	} else if err != nil {
+		serverError(w, r, "Failed to update profile", err)
 		return
 	}
 
+	// Show the confirmation in the newly chosen language
+	i18n.SetPreference(r.Context(), user.Locale)
+
 	h.renderer.Render(w, r, "admin/profile.html", templates.Data{
 		"Title":   "Profile",
 		"User":    user,
@@ -489,39 +769,31 @@ func (h *AdminHandler) ExportDriverCSV(w http.ResponseWriter, r *http.Request) {
 
 	driver, err := h.storage.GetUser(driverID)
 	if err != nil || driver == nil {
+		logError(r, "Failed to load user", err)
 		http.Error(w, "Driver not found", http.StatusNotFound)
 		return
 	}
 
-	// Sort dates chronologically
-	var dates []string
-	for date := range driver.DrivingLog {
-		dates = append(dates, date)
-	}
-	sort.Strings(dates)
-
-	// Sanitize driver name for filename
-	safeName := strings.Map(func(r rune) rune {
-		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' {
-			return r
-		}
-		return '_'
-	}, driver.Name)
-	filename := fmt.Sprintf("%s_driving_hours.csv", safeName)
+	filename := fmt.Sprintf("%s_driving_hours.csv", safeFilename(driver.Name))
 
 	// Set headers for CSV download
 	w.Header().Set("Content-Type", "text/csv")
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
 
-	// Write CSV
+	if err := writeDrivingLogCSV(w, driver); err != nil {
+		logError(r, "Failed to write CSV export", err)
+	}
+}
+
+// writeDrivingLogCSV writes a driver's log as Date/Daytime/Nighttime rows
+func writeDrivingLogCSV(w io.Writer, driver *models.User) error {
 	csvWriter := csv.NewWriter(w)
-	defer csvWriter.Flush()
 
 	// Write header
 	csvWriter.Write([]string{"Date", "Daytime", "Nighttime"})
 
 	// Write data rows
-	for _, date := range dates {
+	for _, date := range sortedDates(driver.DrivingLog) {
 		entry := driver.DrivingLog[date]
 		csvWriter.Write([]string{
 			date,
@@ -529,4 +801,26 @@ func (h *AdminHandler) ExportDriverCSV(w http.ResponseWriter, r *http.Request) {
 			fmt.Sprintf("%.2f", entry.NightHours),
 		})
 	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// sortedDates returns the dates in a driving log in chronological order
+func sortedDates(log models.DrivingLog) []string {
+	var dates []string
+	for date := range log {
+		dates = append(dates, date)
+	}
+	sort.Strings(dates)
+	return dates
+}
+
+// safeFilename replaces anything other than letters, digits and dashes with underscores
+func safeFilename(name string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' {
+			return r
+		}
+		return '_'
+	}, name)
 }
@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"driving-hours/internal/auth"
+	"driving-hours/internal/metrics"
 	"driving-hours/internal/storage"
 	"driving-hours/internal/templates"
 )
@@ -54,6 +55,7 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 
 	user, err := h.storage.GetUserByEmail(email)
 	if err != nil {
+		logError(r, "Failed to look up user for login", err)
 		h.renderer.Render(w, r, "auth/login.html", templates.Data{
 			"Title": "Login",
 			"Error": "An error occurred. Please try again.",
@@ -63,6 +65,7 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if user == nil {
+		metrics.LoginAttempts.Inc("failure")
 		h.renderer.Render(w, r, "auth/login.html", templates.Data{
 			"Title": "Login",
 			"Error": "Invalid email or password",
@@ -73,6 +76,7 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 
 	valid, err := auth.VerifyPassword(password, user.PasswordHash)
 	if err != nil || !valid {
+		metrics.LoginAttempts.Inc("failure")
 		h.renderer.Render(w, r, "auth/login.html", templates.Data{
 			"Title": "Login",
 			"Error": "Invalid email or password",
@@ -81,7 +85,18 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if user.IsArchived() {
+		metrics.LoginAttempts.Inc("failure")
+		h.renderer.Render(w, r, "auth/login.html", templates.Data{
+			"Title": "Login",
+			"Error": "This account has been archived. Please contact your administrator.",
+			"Email": email,
+		})
+		return
+	}
+
 	if err := h.sessions.CreateSession(w, user.ID); err != nil {
+		logError(r, "Failed to create session", err)
 		h.renderer.Render(w, r, "auth/login.html", templates.Data{
 			"Title": "Login",
 			"Error": "An error occurred. Please try again.",
@@ -90,6 +105,8 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	metrics.LoginAttempts.Inc("success")
+
 	if user.IsAdmin() {
 		http.Redirect(w, r, "/admin", http.StatusSeeOther)
 	} else {
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"driving-hours/internal/auth"
+	"driving-hours/internal/backup"
+	"driving-hours/internal/i18n"
+	"driving-hours/internal/templates"
+)
+
+type BackupHandler struct {
+	backups  *backup.Manager
+	renderer *templates.Renderer
+}
+
+func NewBackupHandler(b *backup.Manager, r *templates.Renderer) *BackupHandler {
+	return &BackupHandler{
+		backups:  b,
+		renderer: r,
+	}
+}
+
+func (h *BackupHandler) List(w http.ResponseWriter, r *http.Request) {
+	h.render(w, r, "", "")
+}
+
+func (h *BackupHandler) Create(w http.ResponseWriter, r *http.Request) {
+	info, err := h.backups.Create()
+	if err != nil {
+		logError(r, "Backup failed", err)
+		h.render(w, r, i18n.T(r.Context(), "Backup failed: %v", err), "")
+		return
+	}
+
+	h.render(w, r, "", i18n.T(r.Context(), "Backup %s created", info.Name))
+}
+
+func (h *BackupHandler) Download(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+
+	path, err := h.backups.Path(name)
+	if err != nil {
+		http.Error(w, "Backup not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", name))
+	http.ServeFile(w, r, path)
+}
+
+func (h *BackupHandler) render(w http.ResponseWriter, r *http.Request, errMsg, success string) {
+	backups, err := h.backups.List()
+	if err != nil {
+		serverError(w, r, "Failed to list backups", err)
+		return
+	}
+
+	h.renderer.Render(w, r, "admin/backups.html", templates.Data{
+		"Title":   "Backups",
+		"User":    auth.GetUser(r),
+		"Backups": backups,
+		"Error":   errMsg,
+		"Success": success,
+	})
+}
@@ -0,0 +1,361 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"driving-hours/internal/auth"
+	"driving-hours/internal/i18n"
+	"driving-hours/internal/models"
+	"driving-hours/internal/storage"
+	"driving-hours/internal/templates"
+)
+
+// BulkResult records the outcome of a bulk action for a single user. Message
+// is in the admin's language.
+type BulkResult struct {
+	UserID   string
+	Name     string
+	Email    string
+	OK       bool
+	Message  string
+	Password string
+}
+
+// bulkFunc applies a bulk action to a single user and returns a status message.
+// The user is saved by the caller when no error is returned. Errors are shown
+// to the admin, so their text is a catalog key.
+type bulkFunc func(u *models.User) (string, error)
+
+func (h *AdminHandler) BulkAction(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r)
+	loc := i18n.FromContext(r.Context())
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, loc.T("Invalid form submission"), http.StatusBadRequest)
+		return
+	}
+
+	ids := r.Form["ids"]
+	action := r.FormValue("action")
+
+	if len(ids) == 0 || action == "" {
+		h.renderUsersWithError(w, r, "Select at least one user and an action")
+		return
+	}
+
+	// Exports stream a download instead of saving anything
+	switch action {
+	case "export_csv":
+		h.bulkExportCSV(w, r, ids)
+		return
+	case "export_zip":
+		h.bulkExportZIP(w, r, ids)
+		return
+	}
+
+	var title string
+	var apply bulkFunc
+
+	switch action {
+	case "set_hours":
+		title = "Set Required Hours"
+		dayHours, dayErr := parseOptionalHours(r.FormValue("required_day_hours"))
+		nightHours, nightErr := parseOptionalHours(r.FormValue("required_night_hours"))
+		if dayErr != nil || nightErr != nil {
+			h.renderUsersWithError(w, r, "Required hours must be non-negative numbers")
+			return
+		}
+		if dayHours == nil && nightHours == nil {
+			h.renderUsersWithError(w, r, "Enter required day hours, night hours, or both")
+			return
+		}
+		apply = func(u *models.User) (string, error) {
+			if !u.IsDriver() {
+				return "", fmt.Errorf("not a driver")
+			}
+			if dayHours != nil {
+				u.RequiredDayHours = *dayHours
+			}
+			if nightHours != nil {
+				u.RequiredNightHours = *nightHours
+			}
+			return loc.T("Requires %s day / %s night hours", loc.FormatDecimal(u.RequiredDayHours), loc.FormatDecimal(u.RequiredNightHours)), nil
+		}
+
+	case "assign":
+		title = "Assign Group / Instructor"
+		group := strings.TrimSpace(r.FormValue("group"))
+		instructor := strings.TrimSpace(r.FormValue("instructor"))
+		if group == "" && instructor == "" {
+			h.renderUsersWithError(w, r, "Enter a group, an instructor, or both")
+			return
+		}
+		apply = func(u *models.User) (string, error) {
+			if !u.IsDriver() {
+				return "", fmt.Errorf("not a driver")
+			}
+			if group != "" {
+				u.Group = group
+			}
+			if instructor != "" {
+				u.Instructor = instructor
+			}
+			return loc.T("Assigned"), nil
+		}
+
+	case "archive":
+		title = "Archive Users"
+		apply = func(u *models.User) (string, error) {
+			if u.ID == user.ID {
+				return "", fmt.Errorf("you cannot archive your own account")
+			}
+			if u.IsArchived() {
+				return loc.T("Already archived"), nil
+			}
+			now := time.Now()
+			u.ArchivedAt = &now
+			return loc.T("Archived"), nil
+		}
+
+	case "reset_password":
+		title = "Reset Passwords"
+		apply = func(u *models.User) (string, error) {
+			// Admins change their own passwords, on their profile page
+			if u.ID == user.ID {
+				return "", fmt.Errorf("change your own password on your profile page")
+			}
+			if u.IsAdmin() {
+				return "", fmt.Errorf("cannot reset another admin's password")
+			}
+			password, err := auth.GenerateRandomPassword(16)
+			if err != nil {
+				logError(r, "Failed to generate password", err)
+				return "", fmt.Errorf("failed to reset password")
+			}
+			hash, err := auth.HashPassword(password)
+			if err != nil {
+				logError(r, "Failed to hash password", err)
+				return "", fmt.Errorf("failed to reset password")
+			}
+			u.PasswordHash = hash
+			return password, nil
+		}
+
+	default:
+		h.renderUsersWithError(w, r, "Unknown bulk action")
+		return
+	}
+
+	results := make([]BulkResult, 0, len(ids))
+	for _, id := range ids {
+		result := BulkResult{UserID: id}
+
+		// Each user is changed on its latest copy, so edits made while the
+		// batch runs aren't lost
+		var message string
+		var applyErr error
+		target, err := storage.UpdateUser(h.storage, id, func(u *models.User) error {
+			result.Name = u.Name
+			result.Email = u.Email
+			message, applyErr = apply(u)
+			return applyErr
+		})
+		switch {
+		case applyErr != nil:
+			result.Message = loc.T(applyErr.Error())
+			results = append(results, result)
+			continue
+		case err != nil:
+			logError(r, "Failed to save user", err)
+			result.Message = loc.T("Failed to save user")
+			results = append(results, result)
+			continue
+		case target == nil:
+			result.Message = loc.T("User not found")
+			results = append(results, result)
+			continue
+		}
+
+		result.OK = true
+		if action == "reset_password" {
+			result.Password = message
+			result.Message = loc.T("Password reset")
+		} else {
+			result.Message = message
+		}
+		results = append(results, result)
+	}
+
+	succeeded := 0
+	for _, result := range results {
+		if result.OK {
+			succeeded++
+		}
+	}
+
+	h.renderer.Render(w, r, "admin/bulk_result.html", templates.Data{
+		"Title":          title,
+		"User":           user,
+		"Action":         title,
+		"Results":        results,
+		"Succeeded":      succeeded,
+		"Failed":         len(results) - succeeded,
+		"PasswordsReset": action == "reset_password" && succeeded > 0,
+	})
+}
+
+// renderExportSkipped shows why selected users can't be exported, instead of
+// a download that quietly leaves them out
+func (h *AdminHandler) renderExportSkipped(w http.ResponseWriter, r *http.Request, skipped []BulkResult) {
+	h.renderer.Render(w, r, "admin/bulk_result.html", templates.Data{
+		"Title":         "Export Driving Logs",
+		"User":          auth.GetUser(r),
+		"Action":        "Export Driving Logs",
+		"Results":       skipped,
+		"Succeeded":     0,
+		"Failed":        len(skipped),
+		"ExportSkipped": true,
+	})
+}
+
+func (h *AdminHandler) renderUsersWithError(w http.ResponseWriter, r *http.Request, message string) {
+	users, err := h.storage.GetAllUsers()
+	if err != nil {
+		serverError(w, r, "Failed to load users", err)
+		return
+	}
+
+	h.renderer.Render(w, r, "admin/users.html", templates.Data{
+		"Title": "Manage Users",
+		"User":  auth.GetUser(r),
+		"Users": activeUsers(users),
+		"Error": message,
+	})
+}
+
+// loadDrivers returns the drivers with the given IDs, along with a failed
+// result for each ID that is unknown or not a driver
+func (h *AdminHandler) loadDrivers(r *http.Request, ids []string) ([]*models.User, []BulkResult, error) {
+	loc := i18n.FromContext(r.Context())
+	var drivers []*models.User
+	var skipped []BulkResult
+	for _, id := range ids {
+		driver, err := h.storage.GetUser(id)
+		if err != nil {
+			return nil, nil, err
+		}
+		switch {
+		case driver == nil:
+			skipped = append(skipped, BulkResult{UserID: id, Message: loc.T("User not found")})
+		case !driver.IsDriver():
+			skipped = append(skipped, BulkResult{UserID: id, Name: driver.Name, Email: driver.Email, Message: loc.T("not a driver")})
+		default:
+			drivers = append(drivers, driver)
+		}
+	}
+	return drivers, skipped, nil
+}
+
+func (h *AdminHandler) bulkExportCSV(w http.ResponseWriter, r *http.Request, ids []string) {
+	drivers, skipped, err := h.loadDrivers(r, ids)
+	if err != nil {
+		serverError(w, r, "Failed to load users", err)
+		return
+	}
+	if len(skipped) > 0 {
+		h.renderExportSkipped(w, r, skipped)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"driving_hours.csv\"")
+
+	csvWriter := csv.NewWriter(w)
+	defer csvWriter.Flush()
+
+	csvWriter.Write([]string{"Name", "Email", "Date", "Daytime", "Nighttime"})
+
+	for _, driver := range drivers {
+		for _, date := range sortedDates(driver.DrivingLog) {
+			entry := driver.DrivingLog[date]
+			csvWriter.Write([]string{
+				driver.Name,
+				driver.Email,
+				date,
+				fmt.Sprintf("%.2f", entry.DayHours),
+				fmt.Sprintf("%.2f", entry.NightHours),
+			})
+		}
+	}
+}
+
+func (h *AdminHandler) bulkExportZIP(w http.ResponseWriter, r *http.Request, ids []string) {
+	drivers, skipped, err := h.loadDrivers(r, ids)
+	if err != nil {
+		serverError(w, r, "Failed to load users", err)
+		return
+	}
+	if len(skipped) > 0 {
+		h.renderExportSkipped(w, r, skipped)
+		return
+	}
+
+	// Built in memory so a failure is reported instead of sending a
+	// truncated archive
+	var buf bytes.Buffer
+	if err := writeDriversZIP(&buf, drivers); err != nil {
+		serverError(w, r, "Failed to create ZIP export", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"driving_hours.zip\"")
+	w.Write(buf.Bytes())
+}
+
+// writeDriversZIP writes a ZIP with a CSV of each driver's log, named after
+// the driver. Drivers who share a name get a counter in their file names.
+func writeDriversZIP(w io.Writer, drivers []*models.User) error {
+	zipWriter := zip.NewWriter(w)
+
+	used := make(map[string]bool)
+	for _, driver := range drivers {
+		base := safeFilename(driver.Name)
+		name := base
+		for n := 2; used[name]; n++ {
+			name = fmt.Sprintf("%s_%d", base, n)
+		}
+		used[name] = true
+
+		f, err := zipWriter.Create(name + "_driving_hours.csv")
+		if err != nil {
+			return err
+		}
+		if err := writeDrivingLogCSV(f, driver); err != nil {
+			return err
+		}
+	}
+	return zipWriter.Close()
+}
+
+// parseOptionalHours parses an hours field, returning nil when it was left blank
+func parseOptionalHours(value string) (*float64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	hours, err := strconv.ParseFloat(value, 64)
+	if err != nil || hours < 0 || math.IsNaN(hours) || math.IsInf(hours, 0) {
+		return nil, fmt.Errorf("invalid hours: %q", value)
+	}
+	return &hours, nil
+}
@@ -1,16 +1,21 @@
 package handlers
 
 import (
+	"math"
 	"net/http"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
+	"driving-hours/internal/achievements"
 	"driving-hours/internal/auth"
+	"driving-hours/internal/i18n"
 	"driving-hours/internal/models"
 	"driving-hours/internal/storage"
 	"driving-hours/internal/templates"
 	"driving-hours/internal/utils"
+	"driving-hours/internal/webhooks"
 )
 
 // DrivingEntry represents a driving log entry for template rendering
@@ -23,14 +28,18 @@ type DrivingEntry struct {
 }
 
 type DriverHandler struct {
-	storage  storage.Storage
-	renderer *templates.Renderer
+	storage      storage.Storage
+	renderer     *templates.Renderer
+	achievements *achievements.Announcer
+	webhooks     *webhooks.Dispatcher
 }
 
-func NewDriverHandler(s storage.Storage, r *templates.Renderer) *DriverHandler {
+func NewDriverHandler(s storage.Storage, r *templates.Renderer, a *achievements.Announcer, d *webhooks.Dispatcher) *DriverHandler {
 	return &DriverHandler{
-		storage:  s,
-		renderer: r,
+		storage:      s,
+		renderer:     r,
+		achievements: a,
+		webhooks:     d,
 	}
 }
 
@@ -41,15 +50,17 @@ func (h *DriverHandler) Dashboard(w http.ResponseWriter, r *http.Request) {
 	year, _ := strconv.Atoi(r.URL.Query().Get("year"))
 	month, _ := strconv.Atoi(r.URL.Query().Get("month"))
 
+	// "Now" and "today" are in the driver's timezone, not the server's
+	now := user.Now()
 	if year == 0 {
-		year = time.Now().Year()
+		year = now.Year()
 	}
 	if month == 0 {
-		month = int(time.Now().Month())
+		month = int(now.Month())
 	}
 
 	// Generate calendar data
-	calendar := utils.GetCalendarData(year, month,
+	calendar := utils.GetCalendarData(year, month, now,
 		func(date string) bool {
 			return user.DrivingLog.HasEntry(date)
 		},
@@ -65,7 +76,7 @@ func (h *DriverHandler) Dashboard(w http.ResponseWriter, r *http.Request) {
 			parsedDate, err := time.Parse("2006-01-02", date)
 			formattedDate := date
 			if err == nil {
-				formattedDate = parsedDate.Format("Jan 2, 2006")
+				formattedDate = i18n.FromContext(r.Context()).FormatDate(parsedDate)
 			}
 			entries = append(entries, DrivingEntry{
 				Date:          date,
@@ -84,17 +95,39 @@ func (h *DriverHandler) Dashboard(w http.ResponseWriter, r *http.Request) {
 	// Check for fireworks flag from session flash
 	showFireworks := r.URL.Query().Get("celebrate") == "1"
 
+	// LogHours passes the achievements it just awarded; only show ones the
+	// driver really has
+	var newAchievements []achievements.Definition
+	for _, id := range strings.Split(r.URL.Query().Get("achieved"), ",") {
+		if def := achievements.Get(id); def != nil && user.Achievement(id) != nil {
+			newAchievements = append(newAchievements, *def)
+		}
+	}
+
 	h.renderer.Render(w, r, "driver/dashboard.html", templates.Data{
-		"Title":         "Dashboard",
-		"User":          user,
-		"Greeting":      utils.GetGreeting(),
-		"Calendar":      calendar,
-		"Entries":       entries,
-		"Today":         time.Now().Format("2006-01-02"),
-		"ShowFireworks": showFireworks,
+		"Title":           "Dashboard",
+		"User":            user,
+		"Greeting":        utils.GetGreeting(now),
+		"Calendar":        calendar,
+		"Entries":         entries,
+		"Today":           now.Format(models.DateFormat),
+		"ShowFireworks":   showFireworks,
+		"NewAchievements": newAchievements,
+		"Badges":          achievements.Badges(user),
+		"Error":           logHoursErrors[r.URL.Query().Get("error")],
 	})
 }
 
+// logHoursErrors maps the error codes LogHours redirects with to messages
+var logHoursErrors = map[string]string{
+	"date_required":  "Please choose a date",
+	"invalid_date":   "Please enter a valid date",
+	"future_date":    "You can't log hours for a future date",
+	"invalid_hours":  "Please enter valid hours",
+	"negative_hours": "Hours cannot be negative",
+	"too_many_hours": "Hours cannot exceed 24",
+}
+
 func (h *DriverHandler) LogHours(w http.ResponseWriter, r *http.Request) {
 	user := auth.GetUser(r)
 
@@ -112,64 +145,51 @@ func (h *DriverHandler) LogHours(w http.ResponseWriter, r *http.Request) {
 		redirectBase = "/driver?view=list"
 	}
 
-	if date == "" {
-		if view == "list" {
-			http.Redirect(w, r, "/driver?view=list&error=date_required", http.StatusSeeOther)
-		} else {
-			http.Redirect(w, r, "/driver?error=date_required", http.StatusSeeOther)
-		}
-		return
+	var entry models.DayEntry
+	if !deleteEntry {
+		// Parse hours and minutes
+		dayHours, _ := strconv.ParseFloat(dayHoursStr, 64)
+		dayMinutes, _ := strconv.ParseFloat(dayMinutesStr, 64)
+		nightHours, _ := strconv.ParseFloat(nightHoursStr, 64)
+		nightMinutes, _ := strconv.ParseFloat(nightMinutesStr, 64)
+
+		// Convert to decimal hours
+		entry.DayHours = dayHours + (dayMinutes / 60)
+		entry.NightHours = nightHours + (nightMinutes / 60)
 	}
 
-	// Initialize driving log if nil
-	if user.DrivingLog == nil {
-		user.DrivingLog = make(models.DrivingLog)
+	errCode := validateLogDate(user, date, deleteEntry)
+	if errCode == "" && !deleteEntry {
+		errCode = validateLogHours(entry)
 	}
-
-	// Handle delete action
-	if deleteEntry {
-		delete(user.DrivingLog, date)
-		if err := h.storage.SaveUser(user); err != nil {
-			http.Error(w, "Failed to delete entry", http.StatusInternalServerError)
-			return
+	if errCode != "" {
+		if view == "list" {
+			http.Redirect(w, r, "/driver?view=list&error="+errCode, http.StatusSeeOther)
+		} else {
+			http.Redirect(w, r, "/driver?error="+errCode, http.StatusSeeOther)
 		}
-		http.Redirect(w, r, redirectBase, http.StatusSeeOther)
 		return
 	}
 
-	// Parse hours and minutes
-	dayHours, _ := strconv.ParseFloat(dayHoursStr, 64)
-	dayMinutes, _ := strconv.ParseFloat(dayMinutesStr, 64)
-	nightHours, _ := strconv.ParseFloat(nightHoursStr, 64)
-	nightMinutes, _ := strconv.ParseFloat(nightMinutesStr, 64)
-
-	// Convert to decimal hours
-	totalDayHours := dayHours + (dayMinutes / 60)
-	totalNightHours := nightHours + (nightMinutes / 60)
-
-	// Set entry (replaces existing) or delete if zero
-	if totalDayHours > 0 || totalNightHours > 0 {
-		user.DrivingLog[date] = models.DayEntry{
-			DayHours:   totalDayHours,
-			NightHours: totalNightHours,
-		}
-	} else {
-		delete(user.DrivingLog, date)
-	}
-
-	// Save user
-	if err := h.storage.SaveUser(user); err != nil {
-		http.Error(w, "Failed to save hours", http.StatusInternalServerError)
+	saved, change, err := saveLogEntry(h.storage, user.ID, date, entry)
+	if err != nil || saved == nil {
+		serverError(w, r, "Failed to save hours", err)
 		return
 	}
 
+	h.achievements.Announce(r.Context(), saved, change.earned)
+	logError(r, "Failed to publish webhook", h.webhooks.PublishLogChange(r.Context(), saved, date, change.before, change.wasMet))
+
 	// Only celebrate if hours were actually logged
-	if totalDayHours > 0 || totalNightHours > 0 {
+	if entry.DayHours > 0 || entry.NightHours > 0 {
+		target := "/driver?celebrate=1"
 		if view == "list" {
-			http.Redirect(w, r, "/driver?view=list&celebrate=1", http.StatusSeeOther)
-		} else {
-			http.Redirect(w, r, "/driver?celebrate=1", http.StatusSeeOther)
+			target = "/driver?view=list&celebrate=1"
+		}
+		if len(change.earned) > 0 {
+			target += "&achieved=" + achievementIDs(change.earned)
 		}
+		http.Redirect(w, r, target, http.StatusSeeOther)
 	} else {
 		http.Redirect(w, r, redirectBase, http.StatusSeeOther)
 	}
@@ -190,6 +210,8 @@ func (h *DriverHandler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
 	name := r.FormValue("name")
 	currentPassword := r.FormValue("current_password")
 	newPassword := r.FormValue("new_password")
+	timezone := strings.TrimSpace(r.FormValue("timezone"))
+	locale := r.FormValue("locale")
 
 	var errors []string
 	var success string
@@ -197,6 +219,12 @@ func (h *DriverHandler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
 	if name == "" {
 		errors = append(errors, "Name is required")
 	}
+	if ok, msg := utils.ValidateTimezone(timezone); !ok {
+		errors = append(errors, msg)
+	}
+	if ok, msg := utils.ValidateLocale(locale); !ok {
+		errors = append(errors, msg)
+	}
 
 	// If changing password, validate current password
 	if newPassword != "" {
@@ -209,6 +237,9 @@ func (h *DriverHandler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 	}
+	if formVersion(r) != user.Version {
+		errors = append(errors, changedMessage)
+	}
 
 	if len(errors) > 0 {
 		h.renderer.Render(w, r, "driver/profile.html", templates.Data{
@@ -221,11 +252,14 @@ func (h *DriverHandler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	user.Name = name
+	user.Timezone = timezone
+	user.Locale = locale
+	user.ReminderOptOut = r.FormValue("reminders") != "1"
 
 	if newPassword != "" {
 		hash, err := auth.HashPassword(newPassword)
 		if err != nil {
-			http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+			serverError(w, r, "Failed to hash password", err)
 			return
 		}
 		user.PasswordHash = hash
@@ -234,14 +268,115 @@ func (h *DriverHandler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
 		success = "Profile updated successfully"
 	}
 
-	if err := h.storage.SaveUser(user); err != nil {
-		http.Error(w, "Failed to update profile", http.StatusInternalServerError)
+	if err := h.storage.SaveUser(user); isConflict(err) {
+		h.renderer.Render(w, r, "driver/profile.html", templates.Data{
+			"Title":  "Profile",
+			"User":   user,
+			"Errors": []string{changedMessage},
+			"Name":   name,
+		})
+		return
+	} else if err != nil {
+		serverError(w, r, "Failed to update profile", err)
 		return
 	}
 
+	// Show the confirmation in the newly chosen language
+	i18n.SetPreference(r.Context(), user.Locale)
+
 	h.renderer.Render(w, r, "driver/profile.html", templates.Data{
 		"Title":   "Profile",
 		"User":    user,
 		"Success": success,
 	})
 }
+
+// logChange is what setting a day's hours changed, for the announcements
+// made once it is saved
+type logChange struct {
+	before models.DayEntry // the entry it replaced
+	wasMet bool            // whether requirements were met before
+	earned []achievements.Definition
+}
+
+// saveLogEntry replaces a driver's hours for date, removing the entry if both
+// are zero, and awards any achievements the new total earns. Only the one
+// entry is written, so it can't undo a concurrent edit to the driver's
+// profile or another day. It returns the driver as saved, or nil if they
+// don't exist.
+func saveLogEntry(s storage.Storage, driverID, date string, entry models.DayEntry) (*models.User, logChange, error) {
+	var change logChange
+	driver, err := s.GetUser(driverID)
+	if err != nil || driver == nil {
+		return nil, change, err
+	}
+	change.before = driver.DrivingLog.GetEntry(date)
+	change.wasMet = driver.RequirementsMet()
+
+	if entry.DayHours > 0 || entry.NightHours > 0 {
+		err = s.PutLogEntry(driverID, date, entry)
+	} else {
+		err = s.DeleteLogEntry(driverID, date)
+	}
+	if err != nil {
+		return nil, change, err
+	}
+
+	driver, err = s.GetUser(driverID)
+	if err != nil || driver == nil {
+		return nil, change, err
+	}
+
+	// Most entries earn nothing, and then the user record is left alone
+	now := time.Now()
+	if len(achievements.Award(driver, now)) == 0 {
+		return driver, change, nil
+	}
+	driver, err = storage.UpdateUser(s, driverID, func(u *models.User) error {
+		change.earned = achievements.Award(u, now)
+		return nil
+	})
+	return driver, change, err
+}
+
+// validateLogDate checks a driving log date, judging "future" by the driver's
+// own calendar. Future entries may still be deleted. It returns an error code
+// from logHoursErrors, or "" if the date is valid.
+func validateLogDate(driver *models.User, date string, deleting bool) string {
+	switch {
+	case date == "":
+		return "date_required"
+	case !utils.ValidateDate(date):
+		return "invalid_date"
+	case !deleting && driver.IsFutureDate(date):
+		return "future_date"
+	}
+	return ""
+}
+
+// validateLogHours checks that an entry's hours are possible: neither
+// negative nor more than 24 in a day. It returns an error code from
+// logHoursErrors, or "" if the hours are valid.
+func validateLogHours(entry models.DayEntry) string {
+	for _, hours := range []float64{entry.DayHours, entry.NightHours} {
+		if math.IsNaN(hours) || math.IsInf(hours, 0) {
+			return "invalid_hours"
+		}
+		if hours < 0 {
+			return "negative_hours"
+		}
+	}
+	if ok, _ := utils.ValidateHours(entry.DayHours + entry.NightHours); !ok {
+		return "too_many_hours"
+	}
+	return ""
+}
+
+// achievementIDs joins the IDs of defs with commas for a query string
+func achievementIDs(defs []achievements.Definition) string {
+	ids := make([]string, len(defs))
+	for i, def := range defs {
+		ids[i] = def.ID
+	}
+	return strings.Join(ids, ",")
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"driving-hours/internal/i18n"
+	"driving-hours/internal/logging"
+	"driving-hours/internal/storage"
+)
+
+// changedMessage is shown when a form is submitted for a record someone else
+// saved after the form was opened
+const changedMessage = "This record was changed by someone else after you opened this page. Reload the page to see their changes, then make yours again."
+
+// isConflict reports whether a save was rejected because the record changed
+// after it was loaded
+func isConflict(err error) bool {
+	return errors.Is(err, storage.ErrConflict)
+}
+
+// isEmailTaken reports whether a save was rejected because another user has
+// the email
+func isEmailTaken(err error) bool {
+	return errors.Is(err, storage.ErrEmailTaken)
+}
+
+// formVersion is the record version a form was filled in from
+func formVersion(r *http.Request) int64 {
+	version, _ := strconv.ParseInt(r.FormValue("version"), 10, 64)
+	return version
+}
+
+// serverError logs err with the request's context and responds with a 500.
+// message is logged as is and shown in the user's language.
+func serverError(w http.ResponseWriter, r *http.Request, message string, err error) {
+	logging.FromContext(r.Context()).Error(message, "error", err)
+	http.Error(w, i18n.T(r.Context(), message), http.StatusInternalServerError)
+}
+
+// logError logs an error the handler recovers from, for example by redirecting
+func logError(r *http.Request, message string, err error) {
+	if err != nil {
+		logging.FromContext(r.Context()).Error(message, "error", err)
+	}
+}
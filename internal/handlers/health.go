@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"driving-hours/internal/storage"
+	"driving-hours/internal/templates"
+)
+
+type HealthHandler struct {
+	storage  storage.Storage
+	renderer *templates.Renderer
+}
+
+func NewHealthHandler(s storage.Storage, r *templates.Renderer) *HealthHandler {
+	return &HealthHandler{
+		storage:  s,
+		renderer: r,
+	}
+}
+
+// Healthz reports that the process is alive
+func (h *HealthHandler) Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+// Readyz reports whether storage is usable and templates are loaded
+func (h *HealthHandler) Readyz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{
+		"storage":   "ok",
+		"templates": "ok",
+	}
+	status := http.StatusOK
+
+	// The error names paths on the server, so it only goes to the log
+	if err := h.storage.Ping(); err != nil {
+		logError(r, "Readiness check failed", err)
+		checks["storage"] = "storage unavailable"
+		status = http.StatusServiceUnavailable
+	}
+
+	if !h.renderer.Ready() {
+		checks["templates"] = "not loaded"
+		status = http.StatusServiceUnavailable
+	}
+
+	result := "ok"
+	if status != http.StatusOK {
+		result = "unavailable"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": result,
+		"checks": checks,
+	})
+}
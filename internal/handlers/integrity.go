@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"net/http"
+
+	"driving-hours/internal/auth"
+	"driving-hours/internal/i18n"
+	"driving-hours/internal/storage"
+	"driving-hours/internal/templates"
+)
+
+// problemLabels names each kind of problem on the integrity page
+var problemLabels = map[string]string{
+	storage.ProblemUnreadable:      "Unreadable file",
+	storage.ProblemInvalidDate:     "Invalid date",
+	storage.ProblemInvalidHours:    "Invalid hours",
+	storage.ProblemOrphanedSession: "Orphaned session",
+	storage.ProblemOrphanedLog:     "Orphaned log",
+	storage.ProblemDuplicateEmail:  "Duplicate email",
+	storage.ProblemEmailIndex:      "Stale email index",
+	storage.ProblemTempFile:        "Leftover temp file",
+}
+
+type IntegrityHandler struct {
+	storage  storage.Storage
+	renderer *templates.Renderer
+}
+
+func NewIntegrityHandler(s storage.Storage, r *templates.Renderer) *IntegrityHandler {
+	return &IntegrityHandler{
+		storage:  s,
+		renderer: r,
+	}
+}
+
+// Check validates the data directory and lists what is wrong
+func (h *IntegrityHandler) Check(w http.ResponseWriter, r *http.Request) {
+	report, err := h.storage.CheckIntegrity(false)
+	if err != nil {
+		serverError(w, r, "Failed to check data integrity", err)
+		return
+	}
+	h.render(w, r, report, "", "")
+}
+
+// Repair fixes the problems that are safe to fix and shows what was changed
+func (h *IntegrityHandler) Repair(w http.ResponseWriter, r *http.Request) {
+	report, err := h.storage.CheckIntegrity(true)
+	if err != nil {
+		logError(r, "Integrity repair failed", err)
+		if report == nil {
+			serverError(w, r, "Failed to check data integrity", err)
+			return
+		}
+		h.render(w, r, report, i18n.T(r.Context(), "Repair stopped: %v", err), "")
+		return
+	}
+	h.render(w, r, report, "", i18n.T(r.Context(), "Repaired %d problems", report.Repaired()))
+}
+
+func (h *IntegrityHandler) render(w http.ResponseWriter, r *http.Request, report *storage.IntegrityReport, errMsg, success string) {
+	h.renderer.Render(w, r, "admin/integrity.html", templates.Data{
+		"Title":         "Data Integrity",
+		"User":          auth.GetUser(r),
+		"Report":        report,
+		"ProblemLabels": problemLabels,
+		"Error":         errMsg,
+		"Success":       success,
+	})
+}
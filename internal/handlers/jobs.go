@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"driving-hours/internal/auth"
+	"driving-hours/internal/i18n"
+	"driving-hours/internal/jobs"
+	"driving-hours/internal/templates"
+)
+
+type JobsHandler struct {
+	scheduler *jobs.Scheduler
+	renderer  *templates.Renderer
+}
+
+func NewJobsHandler(s *jobs.Scheduler, r *templates.Renderer) *JobsHandler {
+	return &JobsHandler{
+		scheduler: s,
+		renderer:  r,
+	}
+}
+
+func (h *JobsHandler) List(w http.ResponseWriter, r *http.Request) {
+	h.render(w, r, "", "")
+}
+
+func (h *JobsHandler) Run(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+
+	if !h.scheduler.RunNow(r.Context(), name) {
+		h.render(w, r, i18n.T(r.Context(), "Job %s is unknown or already running", name), "")
+		return
+	}
+
+	h.render(w, r, "", i18n.T(r.Context(), "Job %s finished", name))
+}
+
+func (h *JobsHandler) render(w http.ResponseWriter, r *http.Request, errMsg, success string) {
+	h.renderer.Render(w, r, "admin/jobs.html", templates.Data{
+		"Title":   "Background Jobs",
+		"User":    auth.GetUser(r),
+		"Jobs":    h.scheduler.Status(),
+		"Error":   errMsg,
+		"Success": success,
+	})
+}
@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"driving-hours/internal/auth"
+	"driving-hours/internal/i18n"
+	"driving-hours/internal/models"
+	"driving-hours/internal/storage"
+	"driving-hours/internal/templates"
+	"driving-hours/internal/webhooks"
+)
+
+// deliveryLogSize is how many recent deliveries the webhook page shows
+const deliveryLogSize = 50
+
+type WebhooksHandler struct {
+	storage    storage.Storage
+	dispatcher *webhooks.Dispatcher
+	renderer   *templates.Renderer
+}
+
+func NewWebhooksHandler(s storage.Storage, d *webhooks.Dispatcher, r *templates.Renderer) *WebhooksHandler {
+	return &WebhooksHandler{
+		storage:    s,
+		dispatcher: d,
+		renderer:   r,
+	}
+}
+
+// webhookRow is a webhook with the outcome of its latest delivery
+type webhookRow struct {
+	*models.Webhook
+	LastDelivery *models.WebhookDelivery
+	Pending      int
+}
+
+func (h *WebhooksHandler) List(w http.ResponseWriter, r *http.Request) {
+	hooks, err := h.storage.GetWebhooks()
+	if err != nil {
+		serverError(w, r, "Failed to load webhooks", err)
+		return
+	}
+
+	rows := make([]webhookRow, len(hooks))
+	for i, hook := range hooks {
+		rows[i].Webhook = hook
+		deliveries, err := h.storage.GetWebhookDeliveries(hook.ID)
+		logError(r, "Failed to load webhook deliveries", err)
+		if len(deliveries) > 0 {
+			rows[i].LastDelivery = deliveries[0]
+		}
+		for _, d := range deliveries {
+			if d.IsPending() {
+				rows[i].Pending++
+			}
+		}
+	}
+
+	h.renderer.Render(w, r, "admin/webhooks.html", templates.Data{
+		"Title":    "Webhooks",
+		"User":     auth.GetUser(r),
+		"Webhooks": rows,
+	})
+}
+
+func (h *WebhooksHandler) NewForm(w http.ResponseWriter, r *http.Request) {
+	h.renderForm(w, r, &models.Webhook{Active: true}, true, nil)
+}
+
+func (h *WebhooksHandler) Create(w http.ResponseWriter, r *http.Request) {
+	hook := &models.Webhook{
+		ID:        uuid.New().String(),
+		Secret:    webhooks.NewSecret(),
+		CreatedAt: time.Now(),
+	}
+	if errors := readWebhookForm(r, hook); len(errors) > 0 {
+		h.renderForm(w, r, hook, true, errors)
+		return
+	}
+
+	if err := h.storage.SaveWebhook(hook); err != nil {
+		serverError(w, r, "Failed to save webhook", err)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/webhooks/"+hook.ID, http.StatusSeeOther)
+}
+
+func (h *WebhooksHandler) EditForm(w http.ResponseWriter, r *http.Request) {
+	hook, ok := h.load(w, r)
+	if !ok {
+		return
+	}
+	h.renderForm(w, r, hook, false, nil)
+}
+
+func (h *WebhooksHandler) Update(w http.ResponseWriter, r *http.Request) {
+	hook, ok := h.load(w, r)
+	if !ok {
+		return
+	}
+	if errors := readWebhookForm(r, hook); len(errors) > 0 {
+		h.renderForm(w, r, hook, false, errors)
+		return
+	}
+
+	if r.FormValue("rotate_secret") == "1" {
+		hook.Secret = webhooks.NewSecret()
+	}
+
+	if err := h.storage.SaveWebhook(hook); err != nil {
+		serverError(w, r, "Failed to save webhook", err)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/webhooks/"+hook.ID, http.StatusSeeOther)
+}
+
+func (h *WebhooksHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	hook, ok := h.load(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.storage.DeleteWebhook(hook.ID); err != nil {
+		serverError(w, r, "Failed to delete webhook", err)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/webhooks", http.StatusSeeOther)
+}
+
+// View shows a webhook's settings and its delivery log
+func (h *WebhooksHandler) View(w http.ResponseWriter, r *http.Request) {
+	hook, ok := h.load(w, r)
+	if !ok {
+		return
+	}
+	h.renderView(w, r, hook, "", "")
+}
+
+// Test sends a ping and shows the result
+func (h *WebhooksHandler) Test(w http.ResponseWriter, r *http.Request) {
+	hook, ok := h.load(w, r)
+	if !ok {
+		return
+	}
+
+	delivery, err := h.dispatcher.Test(r.Context(), hook.ID)
+	if err != nil {
+		serverError(w, r, "Failed to send test", err)
+		return
+	}
+	if !delivery.IsDelivered() {
+		h.renderView(w, r, hook, i18n.T(r.Context(), "Test delivery failed: %s", delivery.Error), "")
+		return
+	}
+	h.renderView(w, r, hook, "", i18n.T(r.Context(), "Test delivery succeeded with status %d", delivery.StatusCode))
+}
+
+// Redeliver sends a logged delivery again
+func (h *WebhooksHandler) Redeliver(w http.ResponseWriter, r *http.Request) {
+	hook, ok := h.load(w, r)
+	if !ok {
+		return
+	}
+
+	original, err := h.storage.GetWebhookDelivery(chi.URLParam(r, "delivery"))
+	if err != nil || original == nil || original.WebhookID != hook.ID {
+		logError(r, "Failed to load webhook delivery", err)
+		http.Redirect(w, r, "/admin/webhooks/"+hook.ID, http.StatusSeeOther)
+		return
+	}
+
+	delivery, err := h.dispatcher.Redeliver(r.Context(), original.ID)
+	if err != nil {
+		serverError(w, r, "Failed to redeliver", err)
+		return
+	}
+	if !delivery.IsDelivered() {
+		h.renderView(w, r, hook, i18n.T(r.Context(), "Redelivery failed: %s", delivery.Error), "")
+		return
+	}
+	h.renderView(w, r, hook, "", i18n.T(r.Context(), "Redelivered with status %d", delivery.StatusCode))
+}
+
+// load fetches the webhook named in the URL, redirecting to the list if it doesn't exist
+func (h *WebhooksHandler) load(w http.ResponseWriter, r *http.Request) (*models.Webhook, bool) {
+	hook, err := h.storage.GetWebhook(chi.URLParam(r, "id"))
+	if err != nil || hook == nil {
+		logError(r, "Failed to load webhook", err)
+		http.Redirect(w, r, "/admin/webhooks", http.StatusSeeOther)
+		return nil, false
+	}
+	return hook, true
+}
+
+func (h *WebhooksHandler) renderForm(w http.ResponseWriter, r *http.Request, hook *models.Webhook, isNew bool, errors []string) {
+	title := "Add Webhook"
+	if !isNew {
+		title = "Edit Webhook"
+	}
+
+	h.renderer.Render(w, r, "admin/webhook_form.html", templates.Data{
+		"Title":   title,
+		"User":    auth.GetUser(r),
+		"IsNew":   isNew,
+		"Webhook": hook,
+		"Events":  webhooks.Events,
+		"Errors":  errors,
+	})
+}
+
+func (h *WebhooksHandler) renderView(w http.ResponseWriter, r *http.Request, hook *models.Webhook, errMsg, success string) {
+	deliveries, err := h.storage.GetWebhookDeliveries(hook.ID)
+	if err != nil {
+		serverError(w, r, "Failed to load webhook deliveries", err)
+		return
+	}
+	if len(deliveries) > deliveryLogSize {
+		deliveries = deliveries[:deliveryLogSize]
+	}
+
+	h.renderer.Render(w, r, "admin/webhook.html", templates.Data{
+		"Title":      "Webhook",
+		"User":       auth.GetUser(r),
+		"Webhook":    hook,
+		"Deliveries": deliveries,
+		"Error":      errMsg,
+		"Success":    success,
+	})
+}
+
+// readWebhookForm copies the form into hook and returns any validation errors
+func readWebhookForm(r *http.Request, hook *models.Webhook) []string {
+	r.ParseForm()
+	hook.URL = strings.TrimSpace(r.FormValue("url"))
+	hook.Description = strings.TrimSpace(r.FormValue("description"))
+	hook.Active = r.FormValue("active") == "1"
+
+	hook.Events = nil
+	for _, event := range r.Form["events"] {
+		if webhooks.IsEvent(event) {
+			hook.Events = append(hook.Events, event)
+		}
+	}
+
+	var errors []string
+	if u, err := url.Parse(hook.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errors = append(errors, "Enter an http or https URL")
+	}
+	if len(hook.Events) == 0 {
+		errors = append(errors, "Choose at least one event")
+	}
+	return errors
+}
@@ -0,0 +1,101 @@
+package i18n
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type contextKey string
+
+const requestLocaleKey contextKey = "request_locale"
+
+// requestLocale is stored in the request context once per request. The user's
+// preference is filled in later by the auth middleware, so it is a mutable holder.
+type requestLocale struct {
+	acceptLanguage string
+	preference     string
+}
+
+// Middleware records the request's Accept-Language header so FromContext can
+// pick a locale for it
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := &requestLocale{acceptLanguage: r.Header.Get("Accept-Language")}
+		ctx := context.WithValue(r.Context(), requestLocaleKey, info)
+		w.Header().Add("Vary", "Accept-Language")
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// SetPreference records the signed-in user's chosen locale, which takes
+// precedence over Accept-Language. An empty tag means "use the browser's".
+func SetPreference(ctx context.Context, tag string) {
+	if info, ok := ctx.Value(requestLocaleKey).(*requestLocale); ok {
+		info.preference = tag
+	}
+}
+
+// FromContext picks the locale for a request: the user's preference, then the
+// best Accept-Language match, then the default
+func FromContext(ctx context.Context) *Locale {
+	info, ok := ctx.Value(requestLocaleKey).(*requestLocale)
+	if !ok {
+		return defaultLocale
+	}
+	if loc, ok := locales[info.preference]; ok {
+		return loc
+	}
+	return Match(info.acceptLanguage)
+}
+
+// T translates msg in the request's locale
+func T(ctx context.Context, msg string, args ...any) string {
+	return FromContext(ctx).T(msg, args...)
+}
+
+// Match returns the supported locale that best fits an Accept-Language header,
+// such as "es-MX,es;q=0.9,en;q=0.8", or the default locale if none fits
+func Match(acceptLanguage string) *Locale {
+	type candidate struct {
+		tag string
+		q   float64
+	}
+
+	var candidates []candidate
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q <= 0 {
+			continue
+		}
+		candidates = append(candidates, candidate{strings.ToLower(tag), q})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+
+	for _, c := range candidates {
+		if loc, ok := locales[c.tag]; ok {
+			return loc
+		}
+		// "es-MX" falls back to "es"
+		base, _, _ := strings.Cut(c.tag, "-")
+		if loc, ok := locales[base]; ok {
+			return loc
+		}
+	}
+	return defaultLocale
+}
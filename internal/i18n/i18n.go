@@ -0,0 +1,250 @@
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed locales/*.json
+var catalogFS embed.FS
+
+// Locale is one loaded message catalog together with its formatting rules.
+// Messages are keyed by their English text, so a missing translation falls
+// back to readable English.
+type Locale struct {
+	Tag  string // e.g. "es"
+	Name string // the language's own name, e.g. "Español"
+
+	messages map[string]message
+	formats  formats
+}
+
+// message is a translation, with optional plural forms for N
+type message struct {
+	One   string
+	Other string
+}
+
+func (m *message) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		m.One, m.Other = s, s
+		return nil
+	}
+	var forms struct {
+		One   string `json:"one"`
+		Other string `json:"other"`
+	}
+	if err := json.Unmarshal(b, &forms); err != nil {
+		return fmt.Errorf("message must be a string or {\"one\", \"other\"}: %w", err)
+	}
+	m.One, m.Other = forms.One, forms.Other
+	return nil
+}
+
+type formats struct {
+	Date          string     `json:"date"`     // e.g. "{mon} {d}, {yyyy}"
+	DateTime      string     `json:"datetime"` // e.g. "{mon} {d}, {yyyy} {h}:{mm} {ampm}"
+	Hours         string     `json:"hours"`    // whole hours, e.g. "{h}h"
+	HoursMinutes  string     `json:"hours_minutes"`
+	Decimal       string     `json:"decimal"`
+	Group         string     `json:"group"`
+	Months        [12]string `json:"months"`
+	MonthsShort   [12]string `json:"months_short"`
+	Weekdays      [7]string  `json:"weekdays"` // starting on Sunday
+	WeekdaysShort [7]string  `json:"weekdays_short"`
+}
+
+type catalog struct {
+	Name     string             `json:"name"`
+	Formats  formats            `json:"formats"`
+	Messages map[string]message `json:"messages"`
+}
+
+var (
+	locales       = map[string]*Locale{}
+	available     []*Locale
+	defaultLocale *Locale
+)
+
+func init() {
+	files, err := catalogFS.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+	for _, f := range files {
+		loc, err := loadCatalog(path.Join("locales", f.Name()))
+		if err != nil {
+			panic(err)
+		}
+		locales[loc.Tag] = loc
+		available = append(available, loc)
+	}
+	sort.Slice(available, func(i, j int) bool { return available[i].Tag < available[j].Tag })
+	defaultLocale = locales["en"]
+}
+
+func loadCatalog(name string) (*Locale, error) {
+	data, err := catalogFS.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var c catalog
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("failed to parse catalog %s: %w", name, err)
+	}
+
+	return &Locale{
+		Tag:      strings.TrimSuffix(path.Base(name), ".json"),
+		Name:     c.Name,
+		messages: c.Messages,
+		formats:  c.Formats,
+	}, nil
+}
+
+// Supported reports whether a catalog exists for tag
+func Supported(tag string) bool {
+	_, ok := locales[tag]
+	return ok
+}
+
+// Available returns every loaded locale, sorted by tag
+func Available() []*Locale {
+	return available
+}
+
+// Get returns the locale for tag, or the default locale when there is none
+func Get(tag string) *Locale {
+	if loc, ok := locales[tag]; ok {
+		return loc
+	}
+	return defaultLocale
+}
+
+// SetDefault sets the locale used when neither the user nor the browser asks
+// for a supported one. Call it once at startup.
+func SetDefault(tag string) error {
+	loc, ok := locales[tag]
+	if !ok {
+		return fmt.Errorf("unsupported locale %q", tag)
+	}
+	defaultLocale = loc
+	return nil
+}
+
+// Default returns the organisation default locale
+func Default() *Locale {
+	return defaultLocale
+}
+
+// T translates msg and, when args are given, formats it with fmt.Sprintf
+func (l *Locale) T(msg string, args ...any) string {
+	if m, ok := l.messages[msg]; ok && m.Other != "" {
+		msg = m.Other
+	}
+	if len(args) == 0 {
+		return msg
+	}
+	return fmt.Sprintf(msg, args...)
+}
+
+// N translates msg choosing the singular or plural form for n. n is passed
+// to fmt.Sprintf before args, so the message can include it with %d.
+func (l *Locale) N(msg string, n int, args ...any) string {
+	text := msg
+	if m, ok := l.messages[msg]; ok {
+		text = m.Other
+		if n == 1 && m.One != "" {
+			text = m.One
+		}
+	}
+	if !strings.Contains(text, "%") {
+		return text
+	}
+	return fmt.Sprintf(text, append([]any{n}, args...)...)
+}
+
+// MonthName returns the full name of month
+func (l *Locale) MonthName(month time.Month) string {
+	return l.formats.Months[month-1]
+}
+
+// WeekdayShort returns the abbreviated name of day
+func (l *Locale) WeekdayShort(day time.Weekday) string {
+	return l.formats.WeekdaysShort[day]
+}
+
+// FormatDate formats t as a date, e.g. "Mar 5, 2024" or "5 mar 2024"
+func (l *Locale) FormatDate(t time.Time) string {
+	return l.formatTime(l.formats.Date, t)
+}
+
+// FormatDateTime formats t as a date and time of day
+func (l *Locale) FormatDateTime(t time.Time) string {
+	return l.formatTime(l.formats.DateTime, t)
+}
+
+func (l *Locale) formatTime(layout string, t time.Time) string {
+	hour12 := t.Hour() % 12
+	if hour12 == 0 {
+		hour12 = 12
+	}
+	ampm := "AM"
+	if t.Hour() >= 12 {
+		ampm = "PM"
+	}
+
+	return strings.NewReplacer(
+		"{d}", strconv.Itoa(t.Day()),
+		"{dd}", fmt.Sprintf("%02d", t.Day()),
+		"{month}", l.formats.Months[t.Month()-1],
+		"{mon}", l.formats.MonthsShort[t.Month()-1],
+		"{mm}", fmt.Sprintf("%02d", t.Minute()),
+		"{yyyy}", strconv.Itoa(t.Year()),
+		"{weekday}", l.formats.Weekdays[t.Weekday()],
+		"{h}", strconv.Itoa(hour12),
+		"{HH}", fmt.Sprintf("%02d", t.Hour()),
+		"{ampm}", ampm,
+	).Replace(layout)
+}
+
+// FormatHours formats a number of hours as hours and minutes, e.g. "2h 30m"
+func (l *Locale) FormatHours(h float64) string {
+	hours := int(h)
+	minutes := int((h - float64(hours)) * 60)
+	if minutes == 0 {
+		return strings.ReplaceAll(l.formats.Hours, "{h}", strconv.Itoa(hours))
+	}
+	return strings.NewReplacer(
+		"{h}", strconv.Itoa(hours),
+		"{m}", strconv.Itoa(minutes),
+	).Replace(l.formats.HoursMinutes)
+}
+
+// FormatDecimal formats f with two decimal places and the locale's separators
+func (l *Locale) FormatDecimal(f float64) string {
+	s := strconv.FormatFloat(math.Abs(f), 'f', 2, 64)
+	whole, frac, _ := strings.Cut(s, ".")
+
+	var b strings.Builder
+	if f < 0 && s != "0.00" {
+		b.WriteByte('-')
+	}
+	for i, c := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteString(l.formats.Group)
+		}
+		b.WriteRune(c)
+	}
+	b.WriteString(l.formats.Decimal)
+	b.WriteString(frac)
+	return b.String()
+}
@@ -0,0 +1,189 @@
+package jobs
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Func is the work done by a job. It should return promptly once ctx is cancelled.
+type Func func(ctx context.Context) error
+
+// Status reports the state of a job and the outcome of its last run
+type Status struct {
+	Name         string
+	Interval     time.Duration
+	Running      bool
+	Runs         int
+	LastRun      time.Time
+	LastDuration time.Duration
+	LastError    string
+	NextRun      time.Time
+}
+
+// OK reports whether the last run succeeded (or the job has not run yet)
+func (s Status) OK() bool {
+	return s.LastError == ""
+}
+
+type job struct {
+	name     string
+	interval time.Duration
+	fn       Func
+
+	mu     sync.Mutex
+	status Status
+}
+
+// Scheduler runs registered jobs at fixed intervals until it is stopped
+type Scheduler struct {
+	mu      sync.Mutex
+	jobs    []*job
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	started bool
+}
+
+func NewScheduler() *Scheduler {
+	return &Scheduler{}
+}
+
+// Add registers a job. Jobs with a zero or negative interval are ignored.
+// Jobs must be added before Start.
+func (s *Scheduler) Add(name string, interval time.Duration, fn Func) {
+	if interval <= 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.jobs = append(s.jobs, &job{
+		name:     name,
+		interval: interval,
+		fn:       fn,
+		status:   Status{Name: name, Interval: interval},
+	})
+}
+
+// Start launches every registered job in its own goroutine
+func (s *Scheduler) Start(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started {
+		return
+	}
+	s.started = true
+
+	ctx, s.cancel = context.WithCancel(ctx)
+	for _, j := range s.jobs {
+		s.wg.Add(1)
+		go s.loop(ctx, j)
+	}
+}
+
+// Stop cancels all jobs and waits for any run in progress to finish
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	cancel := s.cancel
+	s.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+	s.wg.Wait()
+}
+
+// RunNow runs a job immediately, outside its schedule. It returns false if no
+// job has that name or the job is already running.
+func (s *Scheduler) RunNow(ctx context.Context, name string) bool {
+	s.mu.Lock()
+	var target *job
+	for _, j := range s.jobs {
+		if j.name == name {
+			target = j
+		}
+	}
+	s.mu.Unlock()
+
+	if target == nil {
+		return false
+	}
+	return target.run(ctx)
+}
+
+// Status returns the status of every job, sorted by name
+func (s *Scheduler) Status() []Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	statuses := make([]Status, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		j.mu.Lock()
+		statuses = append(statuses, j.status)
+		j.mu.Unlock()
+	}
+
+	sort.Slice(statuses, func(i, k int) bool {
+		return statuses[i].Name < statuses[k].Name
+	})
+	return statuses
+}
+
+func (s *Scheduler) loop(ctx context.Context, j *job) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	j.mu.Lock()
+	j.status.NextRun = time.Now().Add(j.interval)
+	j.mu.Unlock()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			j.run(ctx)
+
+			j.mu.Lock()
+			j.status.NextRun = time.Now().Add(j.interval)
+			j.mu.Unlock()
+		}
+	}
+}
+
+// run executes the job once, recording its outcome. Overlapping runs are skipped.
+func (j *job) run(ctx context.Context) bool {
+	j.mu.Lock()
+	if j.status.Running {
+		j.mu.Unlock()
+		return false
+	}
+	j.status.Running = true
+	j.mu.Unlock()
+
+	start := time.Now()
+	err := j.fn(ctx)
+	duration := time.Since(start)
+
+	j.mu.Lock()
+	j.status.Running = false
+	j.status.Runs++
+	j.status.LastRun = start
+	j.status.LastDuration = duration
+	j.status.LastError = ""
+	if err != nil {
+		j.status.LastError = err.Error()
+	}
+	j.mu.Unlock()
+
+	if err != nil {
+		slog.Warn("job failed", "job", j.name, "duration", duration, "error", err)
+	}
+	return true
+}
@@ -0,0 +1,91 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"strings"
+)
+
+// Setup builds the application logger, installs it as the slog default and
+// routes the standard library log package through it
+func Setup(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q: use debug, info, warn or error", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	case "text", "":
+		handler = slog.NewTextHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("invalid log format %q: use json or text", format)
+	}
+
+	logger := slog.New(handler)
+	slog.SetDefault(logger)
+
+	// Anything still using the log package ends up as an info line
+	log.SetFlags(0)
+	log.SetOutput(slog.NewLogLogger(handler, slog.LevelInfo).Writer())
+
+	return logger, nil
+}
+
+type contextKey string
+
+const requestInfoKey contextKey = "request_info"
+
+// requestInfo is stored in the request context once per request. The user ID
+// is filled in later by the auth middleware, so it is a mutable holder.
+type requestInfo struct {
+	id     string
+	userID string
+}
+
+func withRequestInfo(ctx context.Context, info *requestInfo) context.Context {
+	return context.WithValue(ctx, requestInfoKey, info)
+}
+
+func getRequestInfo(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoKey).(*requestInfo)
+	return info
+}
+
+// RequestID returns the ID of the request carried by ctx, if any
+func RequestID(ctx context.Context) string {
+	if info := getRequestInfo(ctx); info != nil {
+		return info.id
+	}
+	return ""
+}
+
+// SetUserID records the authenticated user so it appears on every later log line
+func SetUserID(ctx context.Context, userID string) {
+	if info := getRequestInfo(ctx); info != nil {
+		info.userID = userID
+	}
+}
+
+// FromContext returns the default logger annotated with the request and user IDs
+func FromContext(ctx context.Context) *slog.Logger {
+	logger := slog.Default()
+
+	info := getRequestInfo(ctx)
+	if info == nil {
+		return logger
+	}
+
+	logger = logger.With("request_id", info.id)
+	if info.userID != "" {
+		logger = logger.With("user_id", info.userID)
+	}
+	return logger
+}
@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+)
+
+// Default is the registry exposed on /metrics
+var Default = NewRegistry()
+
+// Application metrics
+var (
+	HTTPRequests = Default.NewCounterVec("http_requests_total",
+		"HTTP requests by method, chi route pattern and status code.",
+		"method", "route", "status")
+
+	HTTPDuration = Default.NewHistogramVec("http_request_duration_seconds",
+		"HTTP request latency by method and chi route pattern.",
+		DefaultBuckets, "method", "route")
+
+	StorageDuration = Default.NewHistogramVec("storage_operation_duration_seconds",
+		"Storage operation latency by operation.",
+		DefaultBuckets, "operation")
+
+	StorageErrors = Default.NewCounterVec("storage_operation_errors_total",
+		"Storage operations that returned an error, by operation.",
+		"operation")
+
+	StorageConflicts = Default.NewCounterVec("storage_conflicts_total",
+		"Saves rejected because the record changed after it was loaded, by operation.",
+		"operation")
+
+	LoginAttempts = Default.NewCounterVec("login_attempts_total",
+		"Login attempts by result (success or failure).",
+		"result")
+
+	EmailDeliveries = Default.NewCounterVec("email_deliveries_total",
+		"Email delivery attempts by template and result (sent, failed or abandoned).",
+		"template", "result")
+
+	WebhookDeliveries = Default.NewCounterVec("webhook_deliveries_total",
+		"Webhook delivery attempts by event and result (delivered, failed or abandoned).",
+		"event", "result")
+
+	PasswordHashDuration = Default.NewHistogramVec("argon2_duration_seconds",
+		"Time spent computing Argon2id hashes, by operation (hash or verify).",
+		[]float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}, "operation")
+)
+
+// Since records the time elapsed since start in a histogram
+func Since(h *HistogramVec, start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+// Middleware records request counts and latencies by chi route pattern.
+// It must be installed on the router so the pattern is known after routing.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := chimiddleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil {
+			if pattern := rctx.RoutePattern(); pattern != "" {
+				route = pattern
+			}
+		}
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		HTTPRequests.Inc(r.Method, route, strconv.Itoa(status))
+		HTTPDuration.Observe(time.Since(start).Seconds(), r.Method, route)
+	})
+}
+
+// Handler serves the registry. When token is set, requests must send it as a
+// bearer token.
+func Handler(reg *Registry, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token != "" {
+			got := r.Header.Get("Authorization")
+			want := "Bearer " + token
+			if subtle.ConstantTimeCompare([]byte(got), []byte(want)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		reg.Write(w)
+	})
+}
@@ -0,0 +1,237 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// collector is anything that can write itself in the Prometheus text format
+type collector interface {
+	write(w io.Writer)
+}
+
+// Registry holds collectors and renders them for scraping
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// Write writes every registered metric in the Prometheus text exposition format
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+// CounterVec is a counter partitioned by label values
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewCounterVec creates and registers a counter
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+	r.register(c)
+	return c
+}
+
+// Inc adds one to the counter for the given label values
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v to the counter for the given label values
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	key := formatLabels(c.labels, labelValues)
+	c.mu.Lock()
+	c.values[key] += v
+	c.mu.Unlock()
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, key, formatFloat(c.values[key]))
+	}
+}
+
+// HistogramVec is a histogram partitioned by label values
+type HistogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogram
+}
+
+type histogram struct {
+	labelValues []string
+	counts      []uint64
+	count       uint64
+	sum         float64
+}
+
+// DefaultBuckets are latency buckets in seconds suited to web requests
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// NewHistogramVec creates and registers a histogram
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histogram)}
+	r.register(h)
+	return h
+}
+
+// Observe records a value for the given label values
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{
+			labelValues: append([]string(nil), labelValues...),
+			counts:      make([]uint64, len(h.buckets)),
+		}
+		h.series[key] = s
+	}
+
+	for i, upper := range h.buckets {
+		if v <= upper {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	bucketNames := append(append([]string(nil), h.labels...), "le")
+
+	for _, key := range keys {
+		s := h.series[key]
+		bucketValues := append(append([]string(nil), s.labelValues...), "")
+		le := len(bucketValues) - 1
+
+		for i, upper := range h.buckets {
+			bucketValues[le] = formatFloat(upper)
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(bucketNames, bucketValues), s.counts[i])
+		}
+		bucketValues[le] = "+Inf"
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(bucketNames, bucketValues), s.count)
+
+		base := formatLabels(h.labels, s.labelValues)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, base, formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, base, s.count)
+	}
+}
+
+// GaugeFunc reports a value computed at scrape time
+type GaugeFunc struct {
+	name string
+	help string
+	fn   func() (float64, error)
+}
+
+// NewGaugeFunc creates and registers a gauge whose value comes from fn.
+// The gauge is omitted from the output when fn returns an error.
+func (r *Registry) NewGaugeFunc(name, help string, fn func() (float64, error)) *GaugeFunc {
+	g := &GaugeFunc{name: name, help: help, fn: fn}
+	r.register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	v, err := g.fn()
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", g.name, g.help, g.name, g.name, formatFloat(v))
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(value))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,214 @@
+package metrics
+
+import (
+	"errors"
+	"time"
+
+	"driving-hours/internal/models"
+	"driving-hours/internal/storage"
+)
+
+// instrumentedStorage records latency and errors for every storage operation
+type instrumentedStorage struct {
+	next storage.Storage
+}
+
+// InstrumentStorage wraps a store so its operations are recorded in the default registry
+func InstrumentStorage(s storage.Storage) storage.Storage {
+	return &instrumentedStorage{next: s}
+}
+
+// observe is deferred with a pointer to the named error result so the final
+// value is seen once the operation returns. Version conflicts are counted
+// apart from errors, since they are expected under concurrent edits.
+func observe(op string, start time.Time, err *error) {
+	Since(StorageDuration, start, op)
+	switch {
+	case errors.Is(*err, storage.ErrConflict):
+		StorageConflicts.Inc(op)
+	case errors.Is(*err, storage.ErrEmailTaken):
+		// Rejected input, not a storage failure
+	case *err != nil:
+		StorageErrors.Inc(op)
+	}
+}
+
+func (s *instrumentedStorage) GetUser(id string) (u *models.User, err error) {
+	defer observe("get_user", time.Now(), &err)
+	return s.next.GetUser(id)
+}
+
+func (s *instrumentedStorage) GetUserByEmail(email string) (u *models.User, err error) {
+	defer observe("get_user_by_email", time.Now(), &err)
+	return s.next.GetUserByEmail(email)
+}
+
+func (s *instrumentedStorage) GetAllUsers() (users []*models.User, err error) {
+	defer observe("get_all_users", time.Now(), &err)
+	return s.next.GetAllUsers()
+}
+
+func (s *instrumentedStorage) GetDrivers() (users []*models.User, err error) {
+	defer observe("get_drivers", time.Now(), &err)
+	return s.next.GetDrivers()
+}
+
+func (s *instrumentedStorage) GetArchivedUsers() (users []*models.User, err error) {
+	defer observe("get_archived_users", time.Now(), &err)
+	return s.next.GetArchivedUsers()
+}
+
+func (s *instrumentedStorage) SaveUser(user *models.User) (err error) {
+	defer observe("save_user", time.Now(), &err)
+	return s.next.SaveUser(user)
+}
+
+func (s *instrumentedStorage) DeleteUser(id string) (err error) {
+	defer observe("delete_user", time.Now(), &err)
+	return s.next.DeleteUser(id)
+}
+
+func (s *instrumentedStorage) PutLogEntry(userID, date string, entry models.DayEntry) (err error) {
+	defer observe("put_log_entry", time.Now(), &err)
+	return s.next.PutLogEntry(userID, date, entry)
+}
+
+func (s *instrumentedStorage) DeleteLogEntry(userID, date string) (err error) {
+	defer observe("delete_log_entry", time.Now(), &err)
+	return s.next.DeleteLogEntry(userID, date)
+}
+
+func (s *instrumentedStorage) ListLogEntries(userID, from, to string) (log models.DrivingLog, err error) {
+	defer observe("list_log_entries", time.Now(), &err)
+	return s.next.ListLogEntries(userID, from, to)
+}
+
+func (s *instrumentedStorage) GetSession(tokenHash string) (session *models.Session, err error) {
+	defer observe("get_session", time.Now(), &err)
+	return s.next.GetSession(tokenHash)
+}
+
+func (s *instrumentedStorage) SaveSession(session *models.Session) (err error) {
+	defer observe("save_session", time.Now(), &err)
+	return s.next.SaveSession(session)
+}
+
+func (s *instrumentedStorage) RefreshSession(session *models.Session) (ok bool, err error) {
+	defer observe("refresh_session", time.Now(), &err)
+	return s.next.RefreshSession(session)
+}
+
+func (s *instrumentedStorage) DeleteSession(tokenHash string) (err error) {
+	defer observe("delete_session", time.Now(), &err)
+	return s.next.DeleteSession(tokenHash)
+}
+
+func (s *instrumentedStorage) CleanExpiredSessions() (err error) {
+	defer observe("clean_expired_sessions", time.Now(), &err)
+	return s.next.CleanExpiredSessions()
+}
+
+func (s *instrumentedStorage) CountSessions() (n int, err error) {
+	defer observe("count_sessions", time.Now(), &err)
+	return s.next.CountSessions()
+}
+
+func (s *instrumentedStorage) GetOutboxMessages() (messages []*models.OutboxMessage, err error) {
+	defer observe("get_outbox_messages", time.Now(), &err)
+	return s.next.GetOutboxMessages()
+}
+
+func (s *instrumentedStorage) GetOutboxMessage(id string) (msg *models.OutboxMessage, err error) {
+	defer observe("get_outbox_message", time.Now(), &err)
+	return s.next.GetOutboxMessage(id)
+}
+
+func (s *instrumentedStorage) SaveOutboxMessage(msg *models.OutboxMessage) (err error) {
+	defer observe("save_outbox_message", time.Now(), &err)
+	return s.next.SaveOutboxMessage(msg)
+}
+
+func (s *instrumentedStorage) DeleteOutboxMessage(id string) (err error) {
+	defer observe("delete_outbox_message", time.Now(), &err)
+	return s.next.DeleteOutboxMessage(id)
+}
+
+func (s *instrumentedStorage) GetWebhooks() (webhooks []*models.Webhook, err error) {
+	defer observe("get_webhooks", time.Now(), &err)
+	return s.next.GetWebhooks()
+}
+
+func (s *instrumentedStorage) GetWebhook(id string) (webhook *models.Webhook, err error) {
+	defer observe("get_webhook", time.Now(), &err)
+	return s.next.GetWebhook(id)
+}
+
+func (s *instrumentedStorage) SaveWebhook(webhook *models.Webhook) (err error) {
+	defer observe("save_webhook", time.Now(), &err)
+	return s.next.SaveWebhook(webhook)
+}
+
+func (s *instrumentedStorage) DeleteWebhook(id string) (err error) {
+	defer observe("delete_webhook", time.Now(), &err)
+	return s.next.DeleteWebhook(id)
+}
+
+func (s *instrumentedStorage) GetWebhookDeliveries(webhookID string) (deliveries []*models.WebhookDelivery, err error) {
+	defer observe("get_webhook_deliveries", time.Now(), &err)
+	return s.next.GetWebhookDeliveries(webhookID)
+}
+
+func (s *instrumentedStorage) GetWebhookDelivery(id string) (delivery *models.WebhookDelivery, err error) {
+	defer observe("get_webhook_delivery", time.Now(), &err)
+	return s.next.GetWebhookDelivery(id)
+}
+
+func (s *instrumentedStorage) SaveWebhookDelivery(delivery *models.WebhookDelivery) (err error) {
+	defer observe("save_webhook_delivery", time.Now(), &err)
+	return s.next.SaveWebhookDelivery(delivery)
+}
+
+func (s *instrumentedStorage) PruneWebhookDeliveries(cutoff time.Time) (n int, err error) {
+	defer observe("prune_webhook_deliveries", time.Now(), &err)
+	return s.next.PruneWebhookDeliveries(cutoff)
+}
+
+func (s *instrumentedStorage) GetAdmin() (u *models.User, err error) {
+	defer observe("get_admin", time.Now(), &err)
+	return s.next.GetAdmin()
+}
+
+func (s *instrumentedStorage) SaveAdmin(admin *models.User) (err error) {
+	defer observe("save_admin", time.Now(), &err)
+	return s.next.SaveAdmin(admin)
+}
+
+func (s *instrumentedStorage) Snapshot(fn func() error) (err error) {
+	defer observe("snapshot", time.Now(), &err)
+	return s.next.Snapshot(fn)
+}
+
+func (s *instrumentedStorage) Ping() (err error) {
+	defer observe("ping", time.Now(), &err)
+	return s.next.Ping()
+}
+
+func (s *instrumentedStorage) CheckIntegrity(repair bool) (report *storage.IntegrityReport, err error) {
+	defer observe("check_integrity", time.Now(), &err)
+	return s.next.CheckIntegrity(repair)
+}
+
+func (s *instrumentedStorage) Migrate(dryRun bool) (report *storage.MigrationReport, err error) {
+	defer observe("migrate", time.Now(), &err)
+	return s.next.Migrate(dryRun)
+}
+
+func (s *instrumentedStorage) StoredSchemaVersion() (version int, err error) {
+	defer observe("stored_schema_version", time.Now(), &err)
+	return s.next.StoredSchemaVersion()
+}
+
+func (s *instrumentedStorage) InitSchema() (err error) {
+	defer observe("init_schema", time.Now(), &err)
+	return s.next.InitSchema()
+}
@@ -4,6 +4,8 @@ import (
 	"net/http"
 
 	"github.com/gorilla/csrf"
+
+	"driving-hours/internal/logging"
 )
 
 // CSRFProtect returns middleware that protects against CSRF attacks
@@ -13,6 +15,7 @@ func CSRFProtect(key []byte, secure bool) func(http.Handler) http.Handler {
 		csrf.Path("/"),
 		csrf.SameSite(csrf.SameSiteLaxMode),
 		csrf.ErrorHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			logging.FromContext(r.Context()).Warn("CSRF validation failed", "reason", csrf.FailureReason(r))
 			http.Error(w, "Invalid CSRF token", http.StatusForbidden)
 		})),
 	}
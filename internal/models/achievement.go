@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+)
+
+// Achievement records a milestone a driver has reached. Once earned it is
+// kept, even if hours are later removed.
+type Achievement struct {
+	ID       string    `json:"id"`
+	EarnedAt time.Time `json:"earned_at"`
+}
+
+// Achievement returns the user's achievement with the given ID, or nil if
+// they haven't earned it
+func (u *User) Achievement(id string) *Achievement {
+	for i := range u.Achievements {
+		if u.Achievements[i].ID == id {
+			return &u.Achievements[i]
+		}
+	}
+	return nil
+}
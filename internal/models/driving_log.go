@@ -25,3 +25,15 @@ func (d DrivingLog) GetEntry(date string) DayEntry {
 	}
 	return DayEntry{}
 }
+
+// LastDate returns the most recent date with hours logged, or "" if there is none
+func (d DrivingLog) LastDate() string {
+	last := ""
+	for date := range d {
+		// Dates in YYYY-MM-DD format sort chronologically as strings
+		if date > last && d.HasEntry(date) {
+			last = date
+		}
+	}
+	return last
+}
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+)
+
+// OutboxMessage is a rendered email waiting to be delivered. It stays in the
+// outbox until it is sent, so a failed delivery can be retried later.
+type OutboxMessage struct {
+	ID        string    `json:"id"`
+	Template  string    `json:"template"`
+	To        string    `json:"to"`
+	Subject   string    `json:"subject"`
+	Text      string    `json:"text"`
+	HTML      string    `json:"html,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+
+	Attempts      int       `json:"attempts"`
+	LastError     string    `json:"last_error,omitempty"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	// Abandoned is set once the message has failed too many times; it is
+	// kept for inspection but no longer retried
+	Abandoned bool `json:"abandoned,omitempty"`
+}
+
+// IsDue reports whether the message should be attempted now
+func (m *OutboxMessage) IsDue(now time.Time) bool {
+	return !m.Abandoned && !now.Before(m.NextAttemptAt)
+}
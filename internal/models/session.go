@@ -1,14 +1,26 @@
 package models
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"time"
 )
 
+// Session is a login. Only a hash of its token is stored, so a copy of the
+// data directory can't be used to sign in.
 type Session struct {
-	Token     string    `json:"token"`
-	UserID    string    `json:"user_id"`
-	ExpiresAt time.Time `json:"expires_at"`
-	CreatedAt time.Time `json:"created_at"`
+	TokenHash  string    `json:"token_hash"`
+	UserID     string    `json:"user_id"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// HashSessionToken returns the hash a session is stored under. Tokens are
+// random, so a fast unsalted hash is enough.
+func HashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
 
 func (s *Session) IsExpired() bool {
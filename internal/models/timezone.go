@@ -0,0 +1,67 @@
+package models
+
+import (
+	"sync"
+	"time"
+)
+
+// DateFormat is the layout of driving log dates
+const DateFormat = "2006-01-02"
+
+var (
+	defaultLocation = time.Local
+	locations       sync.Map // timezone name -> *time.Location
+)
+
+// SetDefaultLocation sets the organisation timezone, used for users who have
+// not chosen their own. Call it once at startup.
+func SetDefaultLocation(loc *time.Location) {
+	defaultLocation = loc
+}
+
+// DefaultLocation returns the organisation timezone
+func DefaultLocation() *time.Location {
+	return defaultLocation
+}
+
+// LoadLocation looks up an IANA timezone name such as "America/Chicago",
+// caching the result
+func LoadLocation(name string) (*time.Location, error) {
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locations.Store(name, loc)
+	return loc, nil
+}
+
+// Location returns the user's timezone, or the organisation default when the
+// user has none or it is no longer valid
+func (u *User) Location() *time.Location {
+	if u.Timezone != "" {
+		if loc, err := LoadLocation(u.Timezone); err == nil {
+			return loc
+		}
+	}
+	return defaultLocation
+}
+
+// Now returns the current time in the user's timezone
+func (u *User) Now() time.Time {
+	return time.Now().In(u.Location())
+}
+
+// Today returns the user's current date as YYYY-MM-DD
+func (u *User) Today() string {
+	return u.Now().Format(DateFormat)
+}
+
+// IsFutureDate reports whether a YYYY-MM-DD date is after today for the user
+func (u *User) IsFutureDate(date string) bool {
+	// Dates in this format sort chronologically as strings
+	return date > u.Today()
+}
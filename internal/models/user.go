@@ -1,6 +1,7 @@
 package models
 
 import (
+	"strings"
 	"time"
 )
 
@@ -12,16 +13,33 @@ const (
 )
 
 type User struct {
-	ID                 string     `json:"id"`
-	Email              string     `json:"email"`
-	Name               string     `json:"name"`
-	PasswordHash       string     `json:"password_hash"`
-	Role               Role       `json:"role"`
-	RequiredDayHours   float64    `json:"required_day_hours,omitempty"`
-	RequiredNightHours float64    `json:"required_night_hours,omitempty"`
-	CreatedAt          time.Time  `json:"created_at"`
-	UpdatedAt          time.Time  `json:"updated_at"`
-	DrivingLog         DrivingLog `json:"driving_log,omitempty"`
+	ID                 string        `json:"id"`
+	Version            int64         `json:"version"`
+	Email              string        `json:"email"`
+	Name               string        `json:"name"`
+	PasswordHash       string        `json:"password_hash"`
+	Role               Role          `json:"role"`
+	RequiredDayHours   float64       `json:"required_day_hours,omitempty"`
+	RequiredNightHours float64       `json:"required_night_hours,omitempty"`
+	Group              string        `json:"group,omitempty"`
+	Instructor         string        `json:"instructor,omitempty"`
+	SupervisorEmail    string        `json:"supervisor_email,omitempty"`
+	Timezone           string        `json:"timezone,omitempty"`
+	Locale             string        `json:"locale,omitempty"`
+	ReminderOptOut     bool          `json:"reminder_opt_out,omitempty"`
+	LastReminderAt     *time.Time    `json:"last_reminder_at,omitempty"`
+	LastDigestAt       *time.Time    `json:"last_digest_at,omitempty"`
+	Achievements       []Achievement `json:"achievements,omitempty"`
+	ArchivedAt         *time.Time    `json:"archived_at,omitempty"`
+	CreatedAt          time.Time     `json:"created_at"`
+	UpdatedAt          time.Time     `json:"updated_at"`
+	DrivingLog         DrivingLog    `json:"driving_log,omitempty"`
+}
+
+// NormalizeEmail returns the form emails are stored and compared in, so
+// addresses differing only in case or surrounding space are the same
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
 }
 
 func (u *User) IsAdmin() bool {
@@ -32,6 +50,10 @@ func (u *User) IsDriver() bool {
 	return u.Role == RoleDriver
 }
 
+func (u *User) IsArchived() bool {
+	return u.ArchivedAt != nil
+}
+
 func (u *User) TotalDayHours() float64 {
 	var total float64
 	for _, entry := range u.DrivingLog {
@@ -74,19 +96,48 @@ func (u *User) NightProgress() float64 {
 	return progress
 }
 
+// WeeklyAverage is the average hours per week over the last four weeks,
+// counting days in the user's timezone
 func (u *User) WeeklyAverage() float64 {
-	now := time.Now()
-	cutoff := now.AddDate(0, 0, -28)
+	today := u.Today()
+	cutoff := u.Now().AddDate(0, 0, -28).Format(DateFormat)
 
 	var total float64
-	for dateStr, entry := range u.DrivingLog {
-		date, err := time.Parse("2006-01-02", dateStr)
-		if err != nil {
+	for date, entry := range u.DrivingLog {
+		if _, err := time.Parse(DateFormat, date); err != nil {
 			continue
 		}
-		if date.After(cutoff) && !date.After(now) {
+		// Dates in YYYY-MM-DD format sort chronologically as strings
+		if date > cutoff && date <= today {
 			total += entry.DayHours + entry.NightHours
 		}
 	}
 	return total / 4
 }
+
+// RequirementsMet reports whether the driver has logged all the hours they
+// need. Drivers without requirements have never met them.
+func (u *User) RequirementsMet() bool {
+	if u.RequiredDayHours <= 0 && u.RequiredNightHours <= 0 {
+		return false
+	}
+	return u.TotalDayHours() >= u.RequiredDayHours && u.TotalNightHours() >= u.RequiredNightHours
+}
+
+// DaysInactive is the number of days, in the user's timezone, since they last
+// logged driving, or since their account was created if they never have
+func (u *User) DaysInactive() int {
+	loc := u.Location()
+	today, _ := time.ParseInLocation(DateFormat, u.Today(), loc)
+
+	since := u.CreatedAt.In(loc)
+	if last := u.DrivingLog.LastDate(); last != "" {
+		if t, err := time.ParseInLocation(DateFormat, last, loc); err == nil {
+			since = t
+		}
+	}
+	since = time.Date(since.Year(), since.Month(), since.Day(), 0, 0, 0, 0, loc)
+
+	// Round rather than truncate so a daylight saving change doesn't lose a day
+	return int((today.Sub(since).Hours() + 12) / 24)
+}
@@ -0,0 +1,63 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+// Webhook is an endpoint that is sent a signed JSON payload whenever one of
+// its subscribed events happens
+type Webhook struct {
+	ID          string    `json:"id"`
+	URL         string    `json:"url"`
+	Description string    `json:"description,omitempty"`
+	Secret      string    `json:"secret"`
+	Events      []string  `json:"events"`
+	Active      bool      `json:"active"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// HasEvent reports whether event is one of the webhook's chosen events
+func (w *Webhook) HasEvent(event string) bool {
+	return slices.Contains(w.Events, event)
+}
+
+// Subscribed reports whether the webhook is active and wants event
+func (w *Webhook) Subscribed(event string) bool {
+	return w.Active && w.HasEvent(event)
+}
+
+// WebhookDelivery is one event sent, or waiting to be sent, to a webhook.
+// Deliveries are kept after they succeed or are abandoned as a log.
+type WebhookDelivery struct {
+	ID        string    `json:"id"`
+	WebhookID string    `json:"webhook_id"`
+	Event     string    `json:"event"`
+	Payload   string    `json:"payload"`
+	CreatedAt time.Time `json:"created_at"`
+
+	Attempts      int        `json:"attempts"`
+	LastAttemptAt *time.Time `json:"last_attempt_at,omitempty"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	StatusCode    int        `json:"status_code,omitempty"`
+	Response      string     `json:"response,omitempty"`
+	Error         string     `json:"error,omitempty"`
+	DeliveredAt   *time.Time `json:"delivered_at,omitempty"`
+	Abandoned     bool       `json:"abandoned,omitempty"`
+}
+
+// IsDelivered reports whether the endpoint accepted the delivery
+func (d *WebhookDelivery) IsDelivered() bool {
+	return d.DeliveredAt != nil
+}
+
+// IsPending reports whether the delivery will still be attempted
+func (d *WebhookDelivery) IsPending() bool {
+	return !d.IsDelivered() && !d.Abandoned
+}
+
+// IsDue reports whether the delivery should be attempted now
+func (d *WebhookDelivery) IsDue(now time.Time) bool {
+	return d.IsPending() && !now.Before(d.NextAttemptAt)
+}
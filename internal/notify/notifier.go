@@ -0,0 +1,262 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/mail"
+	"time"
+
+	"github.com/google/uuid"
+
+	"driving-hours/internal/i18n"
+	"driving-hours/internal/metrics"
+	"driving-hours/internal/models"
+	"driving-hours/internal/retry"
+)
+
+// Outbox is the storage the notifier keeps undelivered messages in
+type Outbox interface {
+	GetOutboxMessages() ([]*models.OutboxMessage, error)
+	GetOutboxMessage(id string) (*models.OutboxMessage, error)
+	SaveOutboxMessage(msg *models.OutboxMessage) error
+	DeleteOutboxMessage(id string) error
+}
+
+// Options configures a Notifier
+type Options struct {
+	// From is the sender, e.g. "Driving Hours <noreply@example.com>"
+	From string
+	// BaseURL is prepended to links in emails, e.g. "https://hours.example.com"
+	BaseURL string
+	// MaxAttempts is how many times a message is tried before it is abandoned
+	MaxAttempts int
+	// RetryDelay is the wait after the first failure; it doubles after each
+	// further failure, up to a day
+	RetryDelay time.Duration
+}
+
+// Recipient is who an email is addressed to and the language to write it in
+type Recipient struct {
+	Email  string
+	Name   string
+	Locale string // empty uses the default locale
+}
+
+// RecipientFor addresses a user by name in their chosen language
+func RecipientFor(u *models.User) Recipient {
+	return Recipient{Email: u.Email, Name: u.Name, Locale: u.Locale}
+}
+
+func (r Recipient) address() string {
+	return (&mail.Address{Name: r.Name, Address: r.Email}).String()
+}
+
+// Notifier renders emails from templates and delivers them through a
+// transport. Every message is saved to the outbox before it is sent and only
+// removed once the transport accepts it, so a failed send is retried by Flush
+// instead of being lost.
+type Notifier struct {
+	outbox    Outbox
+	transport Transport
+	templates *emailTemplates
+	opts      Options
+	retries   *retry.Queue
+}
+
+func New(outbox Outbox, transport Transport, opts Options) (*Notifier, error) {
+	if _, err := mail.ParseAddress(opts.From); err != nil {
+		return nil, fmt.Errorf("invalid sender address %q: %w", opts.From, err)
+	}
+	templates, err := parseTemplates(opts.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Notifier{
+		outbox:    outbox,
+		transport: transport,
+		templates: templates,
+		opts:      opts,
+		retries:   retry.New(opts.MaxAttempts, opts.RetryDelay),
+	}, nil
+}
+
+// Send renders the named template for to, queues it in the outbox and starts
+// delivering it in the background. An error means nothing was queued; a
+// failed delivery is logged and retried later.
+func (n *Notifier) Send(ctx context.Context, to Recipient, template string, data Data) error {
+	msg, err := n.queue(to, template, data)
+	if err != nil {
+		return err
+	}
+
+	n.retries.Go(ctx, func(ctx context.Context) {
+		n.deliver(ctx, msg)
+	})
+	return nil
+}
+
+// SendNow is like Send but waits for the first delivery attempt and returns
+// its error. The message stays queued for retry if the attempt fails.
+func (n *Notifier) SendNow(ctx context.Context, to Recipient, template string, data Data) error {
+	msg, err := n.queue(to, template, data)
+	if err != nil {
+		return err
+	}
+	return n.deliver(ctx, msg)
+}
+
+func (n *Notifier) queue(to Recipient, template string, data Data) (*models.OutboxMessage, error) {
+	if !n.templates.has(template) {
+		return nil, fmt.Errorf("unknown email template %q", template)
+	}
+	if _, err := mail.ParseAddress(to.Email); err != nil {
+		return nil, fmt.Errorf("invalid recipient %q: %w", to.Email, err)
+	}
+
+	// Templates greet by name; an address without one is greeted by its email
+	name := to.Name
+	if name == "" {
+		name = to.Email
+	}
+	view := Data{"Name": name, "Email": to.Email}
+	maps.Copy(view, data)
+
+	subject, text, html, err := n.templates.render(i18n.Get(to.Locale), template, view)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render email %s: %w", template, err)
+	}
+
+	now := time.Now()
+	msg := &models.OutboxMessage{
+		ID:            uuid.New().String(),
+		Template:      template,
+		To:            to.address(),
+		Subject:       subject,
+		Text:          text,
+		HTML:          html,
+		CreatedAt:     now,
+		NextAttemptAt: now,
+	}
+	if err := n.outbox.SaveOutboxMessage(msg); err != nil {
+		return nil, fmt.Errorf("failed to queue email: %w", err)
+	}
+	return msg, nil
+}
+
+// Flush attempts every message in the outbox that is due, one at a time
+func (n *Notifier) Flush(ctx context.Context) error {
+	messages, err := n.outbox.GetOutboxMessages()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	attempted, failed := 0, 0
+	for _, msg := range messages {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !msg.IsDue(now) {
+			continue
+		}
+		attempted++
+		if err := n.deliver(ctx, msg); err != nil {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d emails failed to send", failed, attempted)
+	}
+	return nil
+}
+
+// Retry makes abandoned and waiting messages due again. With an empty id it
+// applies to the whole outbox. It returns how many messages were reset.
+func (n *Notifier) Retry(id string) (int, error) {
+	messages, err := n.outbox.GetOutboxMessages()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, msg := range messages {
+		if id != "" && msg.ID != id {
+			continue
+		}
+		msg.Attempts = 0
+		msg.Abandoned = false
+		msg.NextAttemptAt = time.Now()
+		if err := n.outbox.SaveOutboxMessage(msg); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// Wait blocks until background deliveries started by Send have finished
+func (n *Notifier) Wait() {
+	n.retries.Wait()
+}
+
+// deliver makes one attempt to send msg and records the outcome in the outbox.
+// msg is refreshed from the outbox first, since the copy the caller holds may
+// have been sent or rescheduled since it was read.
+func (n *Notifier) deliver(ctx context.Context, msg *models.OutboxMessage) error {
+	reload := func(now time.Time) (bool, error) {
+		current, err := n.outbox.GetOutboxMessage(msg.ID)
+		if err != nil || current == nil || !current.IsDue(now) {
+			// Already sent, abandoned or waiting for a later retry
+			return false, err
+		}
+		*msg = *current
+		return true, nil
+	}
+	return n.retries.Attempt(msg.ID, reload, func() error {
+		return n.send(ctx, msg)
+	})
+}
+
+// send hands msg to the transport, removing it from the outbox if it is
+// accepted and scheduling a retry if not
+func (n *Notifier) send(ctx context.Context, msg *models.OutboxMessage) error {
+	logger := slog.With("email_id", msg.ID, "template", msg.Template)
+
+	err := n.transport.Send(ctx, &Message{
+		From:    n.opts.From,
+		To:      msg.To,
+		Subject: msg.Subject,
+		Text:    msg.Text,
+		HTML:    msg.HTML,
+	})
+	if err == nil {
+		metrics.EmailDeliveries.Inc(msg.Template, "sent")
+		logger.Info("email sent")
+		if err := n.outbox.DeleteOutboxMessage(msg.ID); err != nil {
+			// It will be sent again, which beats losing it
+			logger.Error("failed to remove sent email from outbox", "error", err)
+		}
+		return nil
+	}
+
+	msg.Attempts++
+	msg.LastError = err.Error()
+	if next, abandon := n.retries.Failed(msg.Attempts, time.Now()); abandon {
+		msg.Abandoned = true
+		metrics.EmailDeliveries.Inc(msg.Template, "abandoned")
+		logger.Error("email abandoned after repeated failures", "attempts", msg.Attempts, "error", err)
+	} else {
+		msg.NextAttemptAt = next
+		metrics.EmailDeliveries.Inc(msg.Template, "failed")
+		logger.Warn("email failed, will retry", "attempts", msg.Attempts, "next_attempt", msg.NextAttemptAt, "error", err)
+	}
+
+	if saveErr := n.outbox.SaveOutboxMessage(msg); saveErr != nil {
+		logger.Error("failed to update outbox", "error", saveErr)
+	}
+	return err
+}
@@ -0,0 +1,131 @@
+package notify
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"strings"
+	texttemplate "text/template"
+
+	"driving-hours/internal/i18n"
+)
+
+// Each email is a pair of files in templates/: name.txt defines "subject" and
+// "text", and name.html defines "content", which is wrapped in layout.html.
+// Both are parsed once per locale so t translates into the recipient's language.
+//
+//go:embed templates
+var templateFS embed.FS
+
+// Data is passed to email templates
+type Data map[string]any
+
+type localeTemplates struct {
+	text map[string]*texttemplate.Template
+	html map[string]*htmltemplate.Template
+}
+
+type emailTemplates struct {
+	locales map[string]*localeTemplates
+}
+
+func parseTemplates(baseURL string) (*emailTemplates, error) {
+	names, err := fs.Glob(templateFS, "templates/*.txt")
+	if err != nil {
+		return nil, err
+	}
+
+	e := &emailTemplates{locales: make(map[string]*localeTemplates)}
+	for _, loc := range i18n.Available() {
+		funcs := templateFuncs(loc, baseURL)
+		lt := &localeTemplates{
+			text: make(map[string]*texttemplate.Template),
+			html: make(map[string]*htmltemplate.Template),
+		}
+
+		for _, file := range names {
+			name := strings.TrimSuffix(path.Base(file), ".txt")
+
+			text, err := texttemplate.New(name).Funcs(texttemplate.FuncMap(funcs)).ParseFS(templateFS, file)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse email template %s: %w", file, err)
+			}
+			lt.text[name] = text
+
+			htmlFile := "templates/" + name + ".html"
+			if _, err := fs.Stat(templateFS, htmlFile); err != nil {
+				continue // plain text only
+			}
+			html, err := htmltemplate.New(name).Funcs(htmltemplate.FuncMap(funcs)).
+				ParseFS(templateFS, "templates/layout.html", htmlFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse email template %s: %w", htmlFile, err)
+			}
+			lt.html[name] = html
+		}
+
+		e.locales[loc.Tag] = lt
+	}
+	return e, nil
+}
+
+func templateFuncs(loc *i18n.Locale, baseURL string) map[string]any {
+	return map[string]any{
+		"t":             loc.T,
+		"tn":            loc.N,
+		"lang":          func() string { return loc.Tag },
+		"formatDate":    loc.FormatDate,
+		"formatHours":   loc.FormatHours,
+		"formatDecimal": loc.FormatDecimal,
+		// url turns a path such as "/driver" into an absolute link
+		"url": func(p string) string {
+			return strings.TrimSuffix(baseURL, "/") + p
+		},
+	}
+}
+
+// has reports whether an email template exists
+func (e *emailTemplates) has(name string) bool {
+	_, ok := e.locales[i18n.Default().Tag].text[name]
+	return ok
+}
+
+// render produces the subject, plain-text body and optional HTML body of an
+// email in loc's language
+func (e *emailTemplates) render(loc *i18n.Locale, name string, data Data) (subject, text, html string, err error) {
+	lt, ok := e.locales[loc.Tag]
+	if !ok {
+		lt = e.locales[i18n.Default().Tag]
+	}
+
+	tmpl, ok := lt.text[name]
+	if !ok {
+		return "", "", "", fmt.Errorf("unknown email template %q", name)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, "subject", data); err != nil {
+		return "", "", "", err
+	}
+	subject = strings.TrimSpace(buf.String())
+
+	buf.Reset()
+	if err := tmpl.ExecuteTemplate(&buf, "text", data); err != nil {
+		return "", "", "", err
+	}
+	text = strings.TrimSpace(buf.String()) + "\n"
+
+	if htmlTmpl, ok := lt.html[name]; ok {
+		data["Subject"] = subject // for the layout's <title>
+		buf.Reset()
+		if err := htmlTmpl.ExecuteTemplate(&buf, "layout", data); err != nil {
+			return "", "", "", err
+		}
+		html = buf.String()
+	}
+
+	return subject, text, html, nil
+}
@@ -0,0 +1,148 @@
+package notify
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Transport delivers a rendered message
+type Transport interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// SMTP encryption modes
+const (
+	SMTPStartTLS = "starttls" // upgrade a plain connection, usually on port 587
+	SMTPTLS      = "tls"      // implicit TLS, usually on port 465
+	SMTPNone     = "none"     // plain text; only for a local relay
+)
+
+// SMTPOptions configures an SMTPTransport
+type SMTPOptions struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	TLS      string // SMTPStartTLS, SMTPTLS or SMTPNone
+	Timeout  time.Duration
+}
+
+// SMTPTransport sends mail through an SMTP server, opening a connection per message
+type SMTPTransport struct {
+	opts SMTPOptions
+}
+
+func NewSMTPTransport(opts SMTPOptions) *SMTPTransport {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	return &SMTPTransport{opts: opts}
+}
+
+func (t *SMTPTransport) Send(ctx context.Context, msg *Message) error {
+	data, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+	from, _ := mail.ParseAddress(msg.From)
+	to, _ := mail.ParseAddress(msg.To)
+
+	ctx, cancel := context.WithTimeout(ctx, t.opts.Timeout)
+	defer cancel()
+
+	addr := net.JoinHostPort(t.opts.Host, strconv.Itoa(t.opts.Port))
+	tlsConfig := &tls.Config{ServerName: t.opts.Host, MinVersion: tls.VersionTLS12}
+
+	var conn net.Conn
+	if t.opts.TLS == SMTPTLS {
+		conn, err = (&tls.Dialer{Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, t.opts.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if t.opts.TLS == SMTPStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return errors.New("server does not support STARTTLS")
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("STARTTLS failed: %w", err)
+		}
+	}
+
+	if t.opts.Username != "" {
+		// PlainAuth refuses to send the password over an unencrypted
+		// connection unless the server is on localhost
+		auth := smtp.PlainAuth("", t.opts.Username, t.opts.Password, t.opts.Host)
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("authentication failed: %w", err)
+		}
+	}
+
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to.Address); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// FileTransport writes each message to an .eml file in a directory instead of
+// sending it, for development and tests. The files open in any mail client.
+type FileTransport struct {
+	dir string
+}
+
+func NewFileTransport(dir string) (*FileTransport, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create mail directory: %w", err)
+	}
+	return &FileTransport{dir: dir}, nil
+}
+
+func (t *FileTransport) Send(ctx context.Context, msg *Message) error {
+	data, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	to, _ := mail.ParseAddress(msg.To)
+	name := fmt.Sprintf("%s-%s.eml",
+		time.Now().UTC().Format("20060102-150405.000000"),
+		strings.NewReplacer("@", "_at_", "/", "_", "\\", "_").Replace(to.Address))
+	return os.WriteFile(filepath.Join(t.dir, name), data, 0600)
+}
@@ -0,0 +1,187 @@
+package reminders
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"driving-hours/internal/models"
+	"driving-hours/internal/notify"
+	"driving-hours/internal/storage"
+)
+
+// Sender queues an email, normally a *notify.Notifier
+type Sender interface {
+	Send(ctx context.Context, to notify.Recipient, template string, data notify.Data) error
+}
+
+// Options configures a Manager
+type Options struct {
+	// InactiveDays is how long a driver can go without logging hours before
+	// they count as stalled
+	InactiveDays int
+	// Interval is the least time between two reminders to the same driver
+	Interval time.Duration
+	// DigestInterval is the least time between two digests to the admin
+	DigestInterval time.Duration
+}
+
+// Stalled is a driver who hasn't logged hours for at least InactiveDays
+type Stalled struct {
+	ID           string
+	Name         string
+	Email        string
+	DaysInactive int
+	LastDrive    time.Time // zero if they have never logged hours
+	TotalHours   float64
+	OptedOut     bool
+}
+
+// Manager finds drivers who have stopped logging hours, nudges them and sends
+// the admin a digest of them. The time of each send is saved on the recipient
+// so restarts and repeated runs don't send it again too soon.
+type Manager struct {
+	store  storage.Storage
+	sender Sender
+	opts   Options
+}
+
+func NewManager(store storage.Storage, sender Sender, opts Options) *Manager {
+	return &Manager{store: store, sender: sender, opts: opts}
+}
+
+// Stalled lists active drivers who haven't driven for InactiveDays and still
+// have hours to log, longest inactive first
+func (m *Manager) Stalled() ([]Stalled, error) {
+	drivers, err := m.store.GetDrivers()
+	if err != nil {
+		return nil, err
+	}
+
+	var stalled []Stalled
+	for _, d := range drivers {
+		days := d.DaysInactive()
+		if days < m.opts.InactiveDays || d.RequirementsMet() {
+			continue
+		}
+
+		s := Stalled{
+			ID:           d.ID,
+			Name:         d.Name,
+			Email:        d.Email,
+			DaysInactive: days,
+			TotalHours:   d.TotalHours(),
+			OptedOut:     d.ReminderOptOut,
+		}
+		if last := d.DrivingLog.LastDate(); last != "" {
+			s.LastDrive, _ = time.ParseInLocation(models.DateFormat, last, d.Location())
+		}
+		stalled = append(stalled, s)
+	}
+
+	sort.Slice(stalled, func(i, j int) bool {
+		if stalled[i].DaysInactive != stalled[j].DaysInactive {
+			return stalled[i].DaysInactive > stalled[j].DaysInactive
+		}
+		return stalled[i].Name < stalled[j].Name
+	})
+	return stalled, nil
+}
+
+// SendReminders emails each stalled driver who hasn't opted out and hasn't
+// had a reminder within Interval
+func (m *Manager) SendReminders(ctx context.Context) error {
+	stalled, err := m.Stalled()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var errs []error
+	for _, s := range stalled {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if s.OptedOut {
+			continue
+		}
+
+		// Reload in case another run reminded them meanwhile
+		driver, err := m.store.GetUser(s.ID)
+		if err != nil || driver == nil {
+			continue
+		}
+		if driver.LastReminderAt != nil && now.Sub(*driver.LastReminderAt) < m.opts.Interval {
+			continue
+		}
+
+		err = m.sender.Send(ctx, notify.RecipientFor(driver), "reminder", notify.Data{
+			"Days":            s.DaysInactive,
+			"LastDrive":       s.LastDrive,
+			"TotalHours":      driver.TotalHours(),
+			"RequiredHours":   driver.RequiredDayHours + driver.RequiredNightHours,
+			"HasRequirements": driver.RequiredDayHours > 0 || driver.RequiredNightHours > 0,
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("reminder to %s: %w", driver.ID, err))
+			continue
+		}
+
+		_, err = storage.UpdateUser(m.store, driver.ID, func(u *models.User) error {
+			u.LastReminderAt = &now
+			return nil
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to record reminder to %s: %w", driver.ID, err))
+			continue
+		}
+		slog.Info("inactivity reminder sent", "user_id", driver.ID, "days_inactive", s.DaysInactive)
+	}
+	return errors.Join(errs...)
+}
+
+// SendDigest emails the admin the list of stalled drivers, at most once per
+// DigestInterval. Nothing is sent while no driver is stalled.
+func (m *Manager) SendDigest(ctx context.Context) error {
+	admin, err := m.store.GetAdmin()
+	if err != nil {
+		return err
+	}
+	if admin == nil {
+		return nil
+	}
+
+	now := time.Now()
+	if admin.LastDigestAt != nil && now.Sub(*admin.LastDigestAt) < m.opts.DigestInterval {
+		return nil
+	}
+
+	stalled, err := m.Stalled()
+	if err != nil {
+		return err
+	}
+	if len(stalled) == 0 {
+		return nil
+	}
+
+	err = m.sender.Send(ctx, notify.RecipientFor(admin), "digest", notify.Data{
+		"Drivers":      stalled,
+		"InactiveDays": m.opts.InactiveDays,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = storage.UpdateAdmin(m.store, func(u *models.User) error {
+		u.LastDigestAt = &now
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record digest: %w", err)
+	}
+	slog.Info("stalled driver digest sent", "drivers", len(stalled))
+	return nil
+}
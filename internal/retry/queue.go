@@ -0,0 +1,95 @@
+package retry
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// maxDelay caps the wait between attempts
+const maxDelay = 24 * time.Hour
+
+// Queue holds what the email outbox and webhook deliveries share: items are
+// stored before they are sent, sent in the background or by a periodic
+// flush, and retried with a growing delay until they succeed or have been
+// tried too often
+type Queue struct {
+	maxAttempts int
+	delay       time.Duration
+
+	mu       sync.Mutex
+	inFlight map[string]bool
+	wg       sync.WaitGroup
+}
+
+// New returns a queue that gives up on an item after maxAttempts and waits
+// delay after its first failure, doubling after each further failure
+func New(maxAttempts int, delay time.Duration) *Queue {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	if delay <= 0 {
+		delay = time.Minute
+	}
+	return &Queue{
+		maxAttempts: maxAttempts,
+		delay:       delay,
+		inFlight:    make(map[string]bool),
+	}
+}
+
+// Go runs fn in the background. fn's context keeps ctx's values but isn't
+// cancelled with it, since the request that queued the item usually finishes
+// before it is sent.
+func (q *Queue) Go(ctx context.Context, fn func(ctx context.Context)) {
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+		fn(context.WithoutCancel(ctx))
+	}()
+}
+
+// Wait blocks until the functions started by Go have returned
+func (q *Queue) Wait() {
+	q.wg.Wait()
+}
+
+// Attempt calls send for the item id unless another attempt at it is under
+// way, as when a background send and a flush pick up the same new item.
+// reload is called first and reports whether the item is still due: the
+// caller's copy may have been sent or rescheduled since it was read.
+// Attempt returns nil without sending if it isn't.
+func (q *Queue) Attempt(id string, reload func(now time.Time) (bool, error), send func() error) error {
+	q.mu.Lock()
+	if q.inFlight[id] {
+		q.mu.Unlock()
+		return nil
+	}
+	q.inFlight[id] = true
+	q.mu.Unlock()
+
+	defer func() {
+		q.mu.Lock()
+		delete(q.inFlight, id)
+		q.mu.Unlock()
+	}()
+
+	due, err := reload(time.Now())
+	if err != nil || !due {
+		return err
+	}
+	return send()
+}
+
+// Failed returns when to try again an item that has now failed attempts
+// times, or abandon if it shouldn't be tried again
+func (q *Queue) Failed(attempts int, now time.Time) (next time.Time, abandon bool) {
+	if attempts >= q.maxAttempts {
+		return time.Time{}, true
+	}
+	delay := q.delay
+	for i := 1; i < attempts && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	return now.Add(min(delay, maxDelay)), false
+}
@@ -0,0 +1,219 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// KeySize is the length of an encryption key: AES-256
+const KeySize = 32
+
+// encryptedMagic starts every encrypted file. Plain files are JSON and start
+// with '{', so the two can't be confused.
+var encryptedMagic = []byte("DHENC1")
+
+const keyIDSize = 4
+
+// ErrNoKey is returned when reading an encrypted file without its key
+var ErrNoKey = errors.New("file is encrypted with a key that is not configured")
+
+// Cipher encrypts files with envelope encryption: each file gets a random
+// data key, the contents are sealed with it using AES-GCM, and the data key
+// is sealed with the primary key and stored alongside. Files sealed with any
+// of the cipher's keys can be read, as can plain files, so an install can
+// turn on encryption or change keys without rewriting everything at once.
+//
+// An encrypted file is laid out as
+//
+//	magic | key ID | wrapped data key (nonce, key, tag) | nonce | sealed contents
+//
+// where the key ID is the start of the key's SHA-256 hash.
+type Cipher struct {
+	primary []byte // nil writes plain files
+	keys    map[string]cipher.AEAD
+}
+
+// NewCipher returns a cipher that encrypts with primary, or writes plain files
+// if primary is nil, and decrypts with primary or any of previous
+func NewCipher(primary []byte, previous ...[]byte) (*Cipher, error) {
+	c := &Cipher{primary: primary, keys: make(map[string]cipher.AEAD)}
+	for _, key := range append([][]byte{primary}, previous...) {
+		if key == nil {
+			continue
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		c.keys[KeyID(key)] = aead
+	}
+	return c, nil
+}
+
+// GenerateKey returns a new random key, base64 encoded as ParseKey expects
+func GenerateKey() (string, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// ParseKey decodes a base64 key
+func ParseKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(key) != KeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes encoded as standard base64", KeySize)
+	}
+	return key, nil
+}
+
+// ReadKeyFile reads a base64 key from a file
+func ReadKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ParseKey(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+// KeyID identifies a key in encrypted files and status output without
+// revealing it
+func KeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:keyIDSize])
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes", KeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Encrypts reports whether the cipher writes encrypted files
+func (c *Cipher) Encrypts() bool {
+	return c != nil && c.primary != nil
+}
+
+// Encrypt seals plain with a new data key, or returns it unchanged if the
+// cipher has no primary key
+func (c *Cipher) Encrypt(plain []byte) ([]byte, error) {
+	if !c.Encrypts() {
+		return plain, nil
+	}
+	id := KeyID(c.primary)
+	kek := c.keys[id]
+
+	dataKey := make([]byte, KeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	dek, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	rawID, _ := hex.DecodeString(id)
+	header := append(append([]byte{}, encryptedMagic...), rawID...)
+
+	out := append([]byte{}, header...)
+	out, err = seal(kek, out, dataKey, header)
+	if err != nil {
+		return nil, err
+	}
+	return seal(dek, out, plain, header)
+}
+
+// seal appends a random nonce and the sealed plaintext to dst
+func seal(aead cipher.AEAD, dst, plaintext, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	dst = append(dst, nonce...)
+	return aead.Seal(dst, nonce, plaintext, additional), nil
+}
+
+// Decrypt opens data sealed by Encrypt with any of the cipher's keys. Data
+// that isn't encrypted is returned unchanged.
+func (c *Cipher) Decrypt(data []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return data, nil
+	}
+
+	headerSize := len(encryptedMagic) + keyIDSize
+	if len(data) < headerSize {
+		return nil, errors.New("encrypted file is truncated")
+	}
+	header := data[:headerSize]
+	id := hex.EncodeToString(header[len(encryptedMagic):])
+
+	var kek cipher.AEAD
+	if c != nil {
+		kek = c.keys[id]
+	}
+	if kek == nil {
+		return nil, fmt.Errorf("%w (key ID %s)", ErrNoKey, id)
+	}
+
+	wrappedSize := kek.NonceSize() + KeySize + kek.Overhead()
+	rest := data[headerSize:]
+	if len(rest) < wrappedSize {
+		return nil, errors.New("encrypted file is truncated")
+	}
+	dataKey, err := open(kek, rest[:wrappedSize], header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	dek, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := open(dek, rest[wrappedSize:], header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt file: %w", err)
+	}
+	return plain, nil
+}
+
+// open splits the nonce off sealed and opens the rest
+func open(aead cipher.AEAD, sealed, additional []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("encrypted file is truncated")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additional)
+}
+
+// IsEncrypted reports whether data was written by Encrypt
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, encryptedMagic)
+}
+
+// EncryptedKeyID returns the ID of the key an encrypted file is sealed with,
+// or "" for a plain file
+func EncryptedKeyID(data []byte) string {
+	headerSize := len(encryptedMagic) + keyIDSize
+	if !IsEncrypted(data) || len(data) < headerSize {
+		return ""
+	}
+	return hex.EncodeToString(data[len(encryptedMagic):headerSize])
+}
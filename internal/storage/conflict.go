@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+
+	"driving-hours/internal/models"
+)
+
+// ErrConflict matches every *ConflictError with errors.Is
+var ErrConflict = errors.New("record was changed by someone else")
+
+// ConflictError is returned when a user is saved from a copy that is no
+// longer the latest: someone else saved it, or deleted it, after it was loaded
+type ConflictError struct {
+	ID       string
+	Expected int64 // the version the caller loaded
+	Actual   int64 // the version in storage
+	Deleted  bool  // the user no longer exists
+}
+
+func (e *ConflictError) Error() string {
+	if e.Deleted {
+		return fmt.Sprintf("user %s was deleted after version %d was loaded", e.ID, e.Expected)
+	}
+	return fmt.Sprintf("user %s was changed by someone else: loaded version %d, stored version %d", e.ID, e.Expected, e.Actual)
+}
+
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// maxUpdateAttempts bounds how often UpdateUser reapplies a change that keeps
+// conflicting
+const maxUpdateAttempts = 5
+
+// UpdateUser loads a user, applies change and saves it. If someone else saved
+// the user in between, it reloads and applies change again, so change must
+// only depend on the user it is given. An error from change is returned
+// without saving. It returns the saved user, or nil if there is no user with
+// that ID.
+func UpdateUser(s Storage, id string, change func(u *models.User) error) (*models.User, error) {
+	return update(func() (*models.User, error) { return s.GetUser(id) }, s.SaveUser, change)
+}
+
+// UpdateAdmin is UpdateUser for the admin account
+func UpdateAdmin(s Storage, change func(u *models.User) error) (*models.User, error) {
+	return update(s.GetAdmin, s.SaveAdmin, change)
+}
+
+func update(load func() (*models.User, error), save func(*models.User) error, change func(u *models.User) error) (*models.User, error) {
+	var conflict error
+	for range maxUpdateAttempts {
+		u, err := load()
+		if err != nil || u == nil {
+			return nil, err
+		}
+		if err := change(u); err != nil {
+			return nil, err
+		}
+
+		err = save(u)
+		if err == nil {
+			return u, nil
+		}
+		if !errors.Is(err, ErrConflict) {
+			return nil, err
+		}
+		conflict = err
+	}
+	return nil, conflict
+}
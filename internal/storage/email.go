@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"driving-hours/internal/models"
+)
+
+// ErrEmailTaken matches every *EmailTakenError with errors.Is
+var ErrEmailTaken = errors.New("email already in use")
+
+// EmailTakenError is returned when a user is saved with an email another
+// user or the admin already has. Emails are compared after NormalizeEmail.
+type EmailTakenError struct {
+	Email   string
+	OwnerID string
+}
+
+func (e *EmailTakenError) Error() string {
+	return fmt.Sprintf("email %s already belongs to user %s", e.Email, e.OwnerID)
+}
+
+func (e *EmailTakenError) Is(target error) bool {
+	return target == ErrEmailTaken
+}
+
+// DuplicateEmail is an email shared by several records, which was possible
+// before emails were normalized
+type DuplicateEmail struct {
+	Email string
+	Users []*models.User
+}
+
+// FindDuplicateEmails groups users whose emails are the same once
+// normalized, sorted by email
+func FindDuplicateEmails(users []*models.User) []DuplicateEmail {
+	byEmail := make(map[string][]*models.User)
+	for _, u := range users {
+		email := models.NormalizeEmail(u.Email)
+		byEmail[email] = append(byEmail[email], u)
+	}
+
+	var duplicates []DuplicateEmail
+	for email, group := range byEmail {
+		if len(group) > 1 {
+			duplicates = append(duplicates, DuplicateEmail{Email: email, Users: group})
+		}
+	}
+	sort.Slice(duplicates, func(i, j int) bool {
+		return duplicates[i].Email < duplicates[j].Email
+	})
+	return duplicates
+}
@@ -23,6 +23,11 @@ type PasswordGenerator func(length int) (string, error)
 
 // Initialize checks for first run and creates admin if needed
 func Initialize(storage Storage, hashPassword PasswordHasher, generatePassword PasswordGenerator) (*InitResult, error) {
+	// A new data directory has nothing to migrate
+	if err := storage.InitSchema(); err != nil {
+		return nil, err
+	}
+
 	admin, err := storage.GetAdmin()
 	if err != nil {
 		return nil, err
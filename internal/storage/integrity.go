@@ -0,0 +1,448 @@
+package storage
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"driving-hours/internal/models"
+	"driving-hours/internal/utils"
+)
+
+// Kinds of problem an integrity check reports
+const (
+	ProblemUnreadable      = "unreadable"
+	ProblemInvalidDate     = "invalid_date"
+	ProblemInvalidHours    = "invalid_hours"
+	ProblemOrphanedSession = "orphaned_session"
+	ProblemOrphanedLog     = "orphaned_log"
+	ProblemDuplicateEmail  = "duplicate_email"
+	ProblemEmailIndex      = "email_index"
+	ProblemTempFile        = "temp_file"
+)
+
+// tempFileAge is how old a temp file must be before it counts as left over
+// rather than a write in progress
+const tempFileAge = time.Minute
+
+// Problem is one thing an integrity check found
+type Problem struct {
+	Kind   string
+	File   string // relative to the data directory
+	Detail string
+	// Repair says what an automatic repair does about it, or is empty when
+	// it needs a person, e.g. restoring the file from a backup
+	Repair   string
+	Repaired bool
+}
+
+// IntegrityReport is the result of checking every stored record
+type IntegrityReport struct {
+	CheckedAt time.Time
+	Files     int
+	Problems  []Problem
+}
+
+// Repairable counts the problems an automatic repair would fix
+func (r *IntegrityReport) Repairable() int {
+	n := 0
+	for _, p := range r.Problems {
+		if p.Repair != "" && !p.Repaired {
+			n++
+		}
+	}
+	return n
+}
+
+// Repaired counts the problems that were fixed
+func (r *IntegrityReport) Repaired() int {
+	n := 0
+	for _, p := range r.Problems {
+		if p.Repaired {
+			n++
+		}
+	}
+	return n
+}
+
+// integrityCheck holds what has been read so far while checking
+type integrityCheck struct {
+	s      *JSONStorage
+	repair bool
+	report *IntegrityReport
+
+	admin      *models.User
+	users      map[string]*models.User
+	unreadable map[string]bool // IDs of users whose files can't be read
+	logs       map[string]models.DrivingLog
+	sessions   map[string]*models.Session // by file name, without .json
+	index      *emailIndexFile
+}
+
+// CheckIntegrity reads every stored record and reports what is wrong. With
+// repair, problems that can be fixed without losing data are fixed and marked
+// Repaired: leftover temp files and orphaned or unreadable sessions are
+// deleted, log dates written in another format are rewritten, and the email
+// index is rebuilt. Everything else is only reported.
+func (s *JSONStorage) CheckIntegrity(repair bool) (*IntegrityReport, error) {
+	if repair {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+	} else {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+	}
+	// Sessions change without the storage lock; keep them still as well
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+
+	c := &integrityCheck{
+		s:          s,
+		repair:     repair,
+		report:     &IntegrityReport{CheckedAt: time.Now()},
+		users:      make(map[string]*models.User),
+		unreadable: make(map[string]bool),
+		logs:       make(map[string]models.DrivingLog),
+		sessions:   make(map[string]*models.Session),
+	}
+
+	for _, check := range []func() error{
+		c.readRecords,
+		c.checkLogs,
+		c.checkSessions,
+		c.checkEmails,
+	} {
+		if err := check(); err != nil {
+			return c.report, err
+		}
+	}
+
+	sort.SliceStable(c.report.Problems, func(i, j int) bool {
+		return c.report.Problems[i].File < c.report.Problems[j].File
+	})
+	return c.report, nil
+}
+
+// add records a problem, running fix first when repairing. fix may be nil
+// when there is no automatic repair.
+func (c *integrityCheck) add(p Problem, fix func() error) error {
+	if fix == nil {
+		p.Repair = ""
+	}
+	if c.repair && fix != nil {
+		if err := fix(); err != nil {
+			return fmt.Errorf("failed to repair %s: %w", p.File, err)
+		}
+		p.Repaired = true
+	}
+	c.report.Problems = append(c.report.Problems, p)
+	return nil
+}
+
+func (c *integrityCheck) rel(path string) string {
+	rel, err := filepath.Rel(c.s.dataDir, path)
+	if err != nil {
+		return path
+	}
+	return filepath.ToSlash(rel)
+}
+
+// readRecords parses every record file and finds leftover temp files
+func (c *integrityCheck) readRecords() error {
+	now := time.Now()
+	for _, dir := range recordDirs {
+		entries, err := os.ReadDir(filepath.Join(c.s.dataDir, dir))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+
+		for _, entry := range entries {
+			path := filepath.Join(c.s.dataDir, dir, entry.Name())
+			if strings.HasPrefix(entry.Name(), ".tmp-") {
+				info, err := entry.Info()
+				if err != nil || now.Sub(info.ModTime()) < tempFileAge {
+					continue
+				}
+				err = c.add(Problem{
+					Kind:   ProblemTempFile,
+					File:   c.rel(path),
+					Detail: fmt.Sprintf("left over from a write interrupted around %s", info.ModTime().Format(time.DateTime)),
+					Repair: "delete the file",
+				}, func() error { return os.Remove(path) })
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			if !entry.Type().IsRegular() || filepath.Ext(entry.Name()) != ".json" {
+				continue
+			}
+
+			c.report.Files++
+			if err := c.readRecord(dir, path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *integrityCheck) readRecord(dir, path string) error {
+	id := strings.TrimSuffix(filepath.Base(path), ".json")
+	var err error
+	var fix func() error
+	repair := ""
+
+	switch {
+	case dir == "users":
+		var user models.User
+		if err = c.s.readFile(path, &user); err == nil {
+			c.users[user.ID] = &user
+		} else {
+			c.unreadable[id] = true
+		}
+	case dir == "logs":
+		var lf logFile
+		if err = c.s.readFile(path, &lf); err == nil {
+			c.logs[id] = lf.Entries
+		}
+	case dir == "sessions":
+		var session models.Session
+		if err = c.s.readFile(path, &session); err == nil {
+			// Keyed by file name, which is what gets deleted
+			c.sessions[id] = &session
+		} else {
+			fix = func() error { return os.Remove(path) }
+			repair = "delete the session; its user signs in again"
+		}
+	case filepath.Base(path) == "admin.json":
+		var admin models.User
+		if err = c.s.readFile(path, &admin); err == nil {
+			c.admin = &admin
+		}
+	case filepath.Base(path) == "emails.json":
+		var index emailIndexFile
+		if err = c.s.readFile(path, &index); err == nil {
+			c.index = &index
+		} else {
+			// checkEmails rebuilds it
+			return nil
+		}
+	default:
+		var v any
+		err = c.s.readFile(path, &v)
+	}
+
+	if err == nil {
+		return nil
+	}
+	return c.add(Problem{
+		Kind:   ProblemUnreadable,
+		File:   c.rel(path),
+		Detail: err.Error(),
+		Repair: repair,
+	}, fix)
+}
+
+// checkLogs validates the dates and hours in every driver's log
+func (c *integrityCheck) checkLogs() error {
+	ids := make([]string, 0, len(c.users))
+	for id := range c.users {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		log, ok := c.logs[id]
+		file := c.rel(c.s.logPath(id))
+		if !ok {
+			// Not moved to its own file yet
+			log = c.users[id].DrivingLog
+			file = c.rel(filepath.Join(c.s.dataDir, "users", id+".json"))
+		}
+		if err := c.checkLog(id, file, log); err != nil {
+			return err
+		}
+	}
+
+	for id := range c.logs {
+		if c.users[id] == nil && !c.unreadable[id] {
+			err := c.add(Problem{
+				Kind:   ProblemOrphanedLog,
+				File:   c.rel(c.s.logPath(id)),
+				Detail: "the log belongs to no user",
+			}, nil)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *integrityCheck) checkLog(userID, file string, log models.DrivingLog) error {
+	dates := make([]string, 0, len(log))
+	for date := range log {
+		dates = append(dates, date)
+	}
+	sort.Strings(dates)
+
+	fixed := maps.Clone(log)
+	for _, date := range dates {
+		entry := log[date]
+
+		if !utils.ValidateDate(date) {
+			p := Problem{
+				Kind:   ProblemInvalidDate,
+				File:   file,
+				Detail: fmt.Sprintf("entry dated %q is not a YYYY-MM-DD date", date),
+			}
+			var fix func() error
+			if normalized, ok := normalizeDate(date); ok {
+				if _, taken := fixed[normalized]; !taken {
+					p.Repair = fmt.Sprintf("move the entry to %s", normalized)
+					fix = func() error {
+						delete(fixed, date)
+						fixed[normalized] = entry
+						return c.s.writeLog(userID, fixed)
+					}
+				}
+			}
+			if err := c.add(p, fix); err != nil {
+				return err
+			}
+		}
+
+		if reason := invalidHours(entry); reason != "" {
+			err := c.add(Problem{
+				Kind:   ProblemInvalidHours,
+				File:   file,
+				Detail: fmt.Sprintf("entry for %s: %s", date, reason),
+			}, nil)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// dateLayouts are formats a date may have been written in by hand or by
+// another tool, tried when repairing a log
+var dateLayouts = []string{"2006-1-2", "2006/1/2", "2006.1.2", time.RFC3339, "2006-01-02T15:04:05"}
+
+// normalizeDate rewrites date as YYYY-MM-DD if it is unambiguously a date in
+// another format
+func normalizeDate(date string) (string, bool) {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, strings.TrimSpace(date)); err == nil {
+			return t.Format(models.DateFormat), true
+		}
+	}
+	return "", false
+}
+
+// invalidHours explains what is impossible about an entry, or returns ""
+func invalidHours(entry models.DayEntry) string {
+	for _, hours := range []float64{entry.DayHours, entry.NightHours} {
+		if math.IsNaN(hours) || math.IsInf(hours, 0) {
+			return "hours are not a number"
+		}
+		if ok, msg := utils.ValidateHours(hours); !ok {
+			return strings.ToLower(msg[:1]) + msg[1:]
+		}
+	}
+	if entry.DayHours+entry.NightHours > 24 {
+		return fmt.Sprintf("%.2f day and %.2f night hours add up to more than 24", entry.DayHours, entry.NightHours)
+	}
+	return ""
+}
+
+// checkSessions finds sessions of users who no longer exist or can't sign in
+func (c *integrityCheck) checkSessions() error {
+	hashes := make([]string, 0, len(c.sessions))
+	for hash := range c.sessions {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+
+	for _, hash := range hashes {
+		session := c.sessions[hash]
+		var reason string
+		switch user := c.users[session.UserID]; {
+		case c.admin != nil && c.admin.ID == session.UserID, c.unreadable[session.UserID]:
+			continue
+		case user == nil:
+			reason = fmt.Sprintf("user %s no longer exists", session.UserID)
+		case user.IsArchived():
+			reason = fmt.Sprintf("user %s is archived", session.UserID)
+		default:
+			continue
+		}
+
+		path := filepath.Join(c.s.dataDir, "sessions", hash+".json")
+		err := c.add(Problem{
+			Kind:   ProblemOrphanedSession,
+			File:   c.rel(path),
+			Detail: reason,
+			Repair: "delete the session",
+		}, func() error { return os.Remove(path) })
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkEmails reports emails shared by several users and an email index that
+// doesn't match the records
+func (c *integrityCheck) checkEmails() error {
+	users := make([]*models.User, 0, len(c.users))
+	for _, u := range c.users {
+		users = append(users, u)
+	}
+
+	accounts := users
+	if c.admin != nil {
+		accounts = append([]*models.User{c.admin}, users...)
+	}
+	for _, d := range FindDuplicateEmails(accounts) {
+		ids := make([]string, len(d.Users))
+		for i, u := range d.Users {
+			ids[i] = u.ID
+		}
+		sort.Strings(ids)
+		err := c.add(Problem{
+			Kind:   ProblemDuplicateEmail,
+			File:   "users/",
+			Detail: fmt.Sprintf("%s is used by %s; give all but one a different email", d.Email, strings.Join(ids, ", ")),
+		}, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Unreadable users are missing from the expected index, so it can't be
+	// judged until they are fixed
+	if len(c.unreadable) > 0 {
+		return nil
+	}
+	want := buildEmailIndex(c.admin, users)
+	if c.index != nil && maps.Equal(c.index.Emails, want.Emails) {
+		return nil
+	}
+	return c.add(Problem{
+		Kind:   ProblemEmailIndex,
+		File:   c.rel(c.s.emailIndexPath()),
+		Detail: "the email index doesn't match the user records, so signing in with the missing emails reads every user",
+		Repair: "rebuild the index",
+	}, func() error { return c.s.saveEmailIndex(want) })
+}
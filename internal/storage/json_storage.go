@@ -1,10 +1,15 @@
 package storage
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 
@@ -13,23 +18,50 @@ import (
 
 type JSONStorage struct {
 	dataDir string
+	cipher  *Cipher
 	mu      sync.RWMutex
+	// sessionsMu is held for reading while a session file is written or
+	// removed, so session writes never wait for each other, and for writing
+	// by operations that read or rewrite every record and must not race them,
+	// and by RefreshSession, which must not race a removal
+	sessionsMu sync.RWMutex
+
+	pingMu         sync.Mutex
+	lastWriteCheck time.Time
 }
 
-func NewJSONStorage(dataDir string) (*JSONStorage, error) {
+// NewJSONStorage opens the data directory. With a cipher, files are encrypted
+// as they are written; files that are still plain are read as they are.
+// Opening writes no records: older data is brought up to date by Migrate.
+func NewJSONStorage(dataDir string, c *Cipher) (*JSONStorage, error) {
 	// Create data directories if they don't exist
 	usersDir := filepath.Join(dataDir, "users")
 	if err := os.MkdirAll(usersDir, 0700); err != nil {
 		return nil, fmt.Errorf("failed to create users directory: %w", err)
 	}
+	logsDir := filepath.Join(dataDir, "logs")
+	if err := os.MkdirAll(logsDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create logs directory: %w", err)
+	}
+	sessionsDir := filepath.Join(dataDir, "sessions")
+	if err := os.MkdirAll(sessionsDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create sessions directory: %w", err)
+	}
 
 	return &JSONStorage{
 		dataDir: dataDir,
+		cipher:  c,
 	}, nil
 }
 
-// writeFile writes data atomically using a temp file and rename
+// writeFile encrypts data if a key is configured and writes it atomically
+// using a temp file and rename
 func (s *JSONStorage) writeFile(path string, data []byte) error {
+	data, err := s.cipher.Encrypt(data)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt %s: %w", path, err)
+	}
+
 	dir := filepath.Dir(path)
 	tmpFile, err := os.CreateTemp(dir, ".tmp-*")
 	if err != nil {
@@ -57,14 +89,156 @@ func (s *JSONStorage) writeFile(path string, data []byte) error {
 	return os.Rename(tmpPath, path)
 }
 
+// Snapshot runs fn while holding the read lock and keeping sessions still, so
+// no writes happen until it returns
+func (s *JSONStorage) Snapshot(fn func() error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+	return fn()
+}
+
+// pingWriteInterval limits how often Ping proves the data directory is
+// writable, so frequent readiness probes don't keep writing files
+const pingWriteInterval = time.Minute
+
+// Ping verifies the data directory can be listed and, at most once per
+// pingWriteInterval, written. It doesn't take the storage lock: the probe
+// file isn't a record, so probes never wait for or block other writes.
+func (s *JSONStorage) Ping() error {
+	if _, err := os.ReadDir(filepath.Join(s.dataDir, "users")); err != nil {
+		return err
+	}
+
+	s.pingMu.Lock()
+	defer s.pingMu.Unlock()
+	if time.Since(s.lastWriteCheck) < pingWriteInterval {
+		return nil
+	}
+
+	path := filepath.Join(s.dataDir, ".ping")
+	if err := s.writeFile(path, []byte("ok")); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	s.lastWriteCheck = time.Now()
+	return nil
+}
+
 func (s *JSONStorage) readFile(path string, v interface{}) error {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return err
 	}
+	data, err = s.cipher.Decrypt(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	data, _, err = upgradeRecord(s.recordKind(path), data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
 	return json.Unmarshal(data, v)
 }
 
+// Encryption
+
+// recordDirs are the directories, relative to the data directory, whose
+// .json files hold the stored records
+var recordDirs = []string{".", "users", "logs", "sessions"}
+
+// recordFiles lists the paths of every stored record
+func (s *JSONStorage) recordFiles() ([]string, error) {
+	var paths []string
+	for _, dir := range recordDirs {
+		entries, err := os.ReadDir(filepath.Join(s.dataDir, dir))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.Type().IsRegular() && filepath.Ext(entry.Name()) == ".json" {
+				paths = append(paths, filepath.Join(s.dataDir, dir, entry.Name()))
+			}
+		}
+	}
+	return paths, nil
+}
+
+// EncryptionStatus counts stored records by the ID of the key they are
+// encrypted with. Plain records are counted under "".
+func (s *JSONStorage) EncryptionStatus() (map[string]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	paths, err := s.recordFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int)
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		counts[EncryptedKeyID(data)]++
+	}
+	return counts, nil
+}
+
+// Reencrypt rewrites every stored record that isn't already in the form the
+// cipher writes: encrypted with its primary key, or plain if it has none. It
+// returns how many records were rewritten.
+func (s *JSONStorage) Reencrypt() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+
+	paths, err := s.recordFiles()
+	if err != nil {
+		return 0, err
+	}
+
+	want := ""
+	if s.cipher.Encrypts() {
+		want = KeyID(s.cipher.primary)
+	}
+
+	count := 0
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return count, err
+		}
+		if EncryptedKeyID(data) == want {
+			continue
+		}
+
+		plain, err := s.cipher.Decrypt(data)
+		if err != nil {
+			return count, fmt.Errorf("%s: %w", path, err)
+		}
+		if err := s.writeFile(path, plain); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
 // User operations
 
 func (s *JSONStorage) GetUser(id string) (*models.User, error) {
@@ -79,32 +253,29 @@ func (s *JSONStorage) GetUser(id string) (*models.User, error) {
 		}
 		return nil, err
 	}
+	if err := s.attachLog(&user); err != nil {
+		return nil, err
+	}
 	return &user, nil
 }
 
 func (s *JSONStorage) GetUserByEmail(email string) (*models.User, error) {
-	// Check admin first
-	admin, err := s.GetAdmin()
-	if err != nil {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, err := s.emailOwner(models.NormalizeEmail(email))
+	if err != nil || id == "" {
 		return nil, err
 	}
-	if admin != nil && admin.Email == email {
-		return admin, nil
-	}
 
-	// Search through users
-	users, err := s.GetAllUsers()
-	if err != nil {
+	user, err := s.readAccount(id)
+	if err != nil || user == nil {
 		return nil, err
 	}
-
-	for _, user := range users {
-		if user.Email == email {
-			return user, nil
-		}
+	if err := s.attachLog(user); err != nil {
+		return nil, err
 	}
-
-	return nil, nil
+	return user, nil
 }
 
 func (s *JSONStorage) GetAllUsers() ([]*models.User, error) {
@@ -128,9 +299,14 @@ func (s *JSONStorage) GetAllUsers() ([]*models.User, error) {
 
 		var user models.User
 		path := filepath.Join(usersDir, entry.Name())
-		if err := s.readFile(path, &user); err != nil {
+		if err := s.readFile(path, &user); errors.Is(err, ErrNoKey) {
+			return nil, err
+		} else if err != nil {
 			continue
 		}
+		if err := s.attachLog(&user); err != nil {
+			return nil, err
+		}
 		users = append(users, &user)
 	}
 
@@ -145,7 +321,7 @@ func (s *JSONStorage) GetDrivers() ([]*models.User, error) {
 
 	var drivers []*models.User
 	for _, user := range users {
-		if user.Role == models.RoleDriver {
+		if user.Role == models.RoleDriver && !user.IsArchived() {
 			drivers = append(drivers, user)
 		}
 	}
@@ -153,125 +329,870 @@ func (s *JSONStorage) GetDrivers() ([]*models.User, error) {
 	return drivers, nil
 }
 
+func (s *JSONStorage) GetArchivedUsers() ([]*models.User, error) {
+	users, err := s.GetAllUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	var archived []*models.User
+	for _, user := range users {
+		if user.IsArchived() {
+			archived = append(archived, user)
+		}
+	}
+
+	return archived, nil
+}
+
 func (s *JSONStorage) SaveUser(user *models.User) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	user.UpdatedAt = time.Now()
-
-	data, err := json.MarshalIndent(user, "", "  ")
+	path := filepath.Join(s.dataDir, "users", user.ID+".json")
+	_, hasLog, err := s.loadLog(user.ID)
 	if err != nil {
 		return err
 	}
-
-	path := filepath.Join(s.dataDir, "users", user.ID+".json")
-	return s.writeFile(path, data)
+	if !hasLog {
+		// Give the user a log file before the log leaves their record: a new
+		// user's log is written with them, an existing user's comes from their
+		// stored record since SaveUser never changes it
+		log := user.DrivingLog
+		var stored models.User
+		err := s.readFile(path, &stored)
+		switch {
+		case err == nil:
+			log = stored.DrivingLog
+		case !os.IsNotExist(err):
+			return err
+		case user.Version != 0:
+			// Deleted meanwhile; saveVersioned reports the conflict
+			return s.saveVersioned(path, user, true)
+		}
+		if log == nil {
+			log = make(models.DrivingLog)
+		}
+		if err := s.writeLog(user.ID, log); err != nil {
+			return err
+		}
+		if err := s.saveVersioned(path, user, true); err != nil {
+			// A stored record still has its log; a rejected new user has none
+			if rmErr := os.Remove(s.logPath(user.ID)); rmErr != nil && !os.IsNotExist(rmErr) {
+				return errors.Join(err, rmErr)
+			}
+			return err
+		}
+		return nil
+	}
+	return s.saveVersioned(path, user, true)
 }
 
 func (s *JSONStorage) DeleteUser(id string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	path := filepath.Join(s.dataDir, "users", id+".json")
-	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+	for _, path := range []string{
+		filepath.Join(s.dataDir, "users", id+".json"),
+		s.logPath(id),
+	} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := s.removeUserSessions(id); err != nil {
+		return err
+	}
+	return s.indexEmail(id, "")
+}
+
+// removeUserSessions signs a user out everywhere by deleting their sessions
+func (s *JSONStorage) removeUserSessions(userID string) error {
+	s.sessionsMu.RLock()
+	defer s.sessionsMu.RUnlock()
+
+	sessions, err := s.loadSessionFiles()
+	if err != nil {
 		return err
 	}
+	for _, session := range sessions {
+		if session.UserID == userID {
+			if err := s.removeSession(session.TokenHash); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
-// Session operations
+// Email index
 
-type sessionsFile struct {
-	Sessions map[string]*models.Session `json:"sessions"`
+// emailIndexFile maps each normalized email to the ID of the user or admin
+// that has it, so logins don't read every user. It is updated whenever a
+// user is saved or deleted, including by other processes such as the CLI.
+// Nothing stops two processes rewriting it at once, so it is only trusted
+// when the record it points to agrees; see emailOwner.
+type emailIndexFile struct {
+	Emails map[string]string `json:"emails"`
 }
 
-func (s *JSONStorage) loadSessions() (*sessionsFile, error) {
-	path := filepath.Join(s.dataDir, "sessions.json")
-	var sf sessionsFile
-	if err := s.readFile(path, &sf); err != nil {
+func (s *JSONStorage) emailIndexPath() string {
+	return filepath.Join(s.dataDir, "emails.json")
+}
+
+func (s *JSONStorage) loadEmailIndex() (*emailIndexFile, error) {
+	var index emailIndexFile
+	if err := s.readFile(s.emailIndexPath(), &index); err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	if index.Emails == nil {
+		index.Emails = make(map[string]string)
+	}
+	return &index, nil
+}
+
+func (s *JSONStorage) saveEmailIndex(index *emailIndexFile) error {
+	return s.writeJSON(s.emailIndexPath(), index)
+}
+
+// indexEmail points email at id, removing id's previous email. An empty email
+// removes id from the index. The index is only rewritten if that changes it,
+// or rebuilt from the records, which id's already matches, if it can't be
+// read. The caller holds the write lock.
+func (s *JSONStorage) indexEmail(id, email string) error {
+	index, err := s.loadEmailIndex()
+	if errors.Is(err, ErrNoKey) {
+		return err
+	} else if err != nil {
+		admin, users, err := s.readAccounts()
+		if err != nil {
+			return err
+		}
+		return s.saveEmailIndex(buildEmailIndex(admin, users))
+	}
+	changed := false
+	for e, owner := range index.Emails {
+		if owner == id && e != email {
+			delete(index.Emails, e)
+			changed = true
+		}
+	}
+	if email != "" && index.Emails[email] != id {
+		index.Emails[email] = id
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return s.saveEmailIndex(index)
+}
+
+// emailOwner returns the ID of the user or admin with email, or "" if nobody
+// has it. The index answers when the record it names still has the email;
+// otherwise, or if the index can't be read, every record is scanned, so an
+// index that lost a user to a concurrent rewrite can't lock them out or let
+// their email be taken. The caller holds the lock.
+func (s *JSONStorage) emailOwner(email string) (string, error) {
+	if email == "" {
+		return "", nil
+	}
+	if index, err := s.loadEmailIndex(); err == nil {
+		if id, ok := index.Emails[email]; ok {
+			account, err := s.readAccount(id)
+			if err != nil {
+				return "", err
+			}
+			if account != nil && models.NormalizeEmail(account.Email) == email {
+				return id, nil
+			}
+		}
+	} else if errors.Is(err, ErrNoKey) {
+		return "", err
+	}
+
+	admin, users, err := s.readAccounts()
+	if err != nil {
+		return "", err
+	}
+	return buildEmailIndex(admin, users).Emails[email], nil
+}
+
+// readAccount reads a user, or the admin if id is theirs. It returns nil if
+// neither exists.
+func (s *JSONStorage) readAccount(id string) (*models.User, error) {
+	var user models.User
+	err := s.readFile(filepath.Join(s.dataDir, "users", id+".json"), &user)
+	if err == nil {
+		return &user, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err := s.readFile(filepath.Join(s.dataDir, "admin.json"), &user); err != nil {
 		if os.IsNotExist(err) {
-			return &sessionsFile{Sessions: make(map[string]*models.Session)}, nil
+			return nil, nil
 		}
 		return nil, err
 	}
-	if sf.Sessions == nil {
-		sf.Sessions = make(map[string]*models.Session)
+	if user.ID != id {
+		return nil, nil
+	}
+	return &user, nil
+}
+
+// indexEmails writes the email index from the stored records unless it
+// already matches them, and returns the index file if it changed or would
+// change with dryRun. Lookups work without it, by reading every user.
+func (s *JSONStorage) indexEmails(dryRun bool) ([]string, error) {
+	var index emailIndexFile
+	readErr := s.readFile(s.emailIndexPath(), &index)
+	if errors.Is(readErr, ErrNoKey) {
+		return nil, readErr
+	}
+
+	admin, users, err := s.readAccounts()
+	if err != nil {
+		return nil, err
+	}
+	want := buildEmailIndex(admin, users)
+	if readErr == nil && maps.Equal(index.Emails, want.Emails) {
+		return nil, nil
+	}
+	if !dryRun {
+		if err := s.saveEmailIndex(want); err != nil {
+			return nil, err
+		}
 	}
-	return &sf, nil
+	return []string{"emails.json"}, nil
 }
 
-func (s *JSONStorage) saveSessions(sf *sessionsFile) error {
-	data, err := json.MarshalIndent(sf, "", "  ")
+// readAccounts reads the admin, nil if there is none, and every user that
+// can be read. Only a missing key is an error, since it would hide them all.
+func (s *JSONStorage) readAccounts() (*models.User, []*models.User, error) {
+	var admin *models.User
+	if err := s.readFile(filepath.Join(s.dataDir, "admin.json"), &admin); err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+
+	usersDir := filepath.Join(s.dataDir, "users")
+	entries, err := os.ReadDir(usersDir)
 	if err != nil {
+		return nil, nil, err
+	}
+	var users []*models.User
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		var user models.User
+		if err := s.readFile(filepath.Join(usersDir, entry.Name()), &user); errors.Is(err, ErrNoKey) {
+			return nil, nil, err
+		} else if err != nil {
+			continue
+		}
+		users = append(users, &user)
+	}
+	return admin, users, nil
+}
+
+// buildEmailIndex indexes the admin, if not nil, and users. An email that
+// several of them share goes to the admin, then the earliest created user.
+func buildEmailIndex(admin *models.User, users []*models.User) *emailIndexFile {
+	accounts := make([]*models.User, 0, len(users)+1)
+	if admin != nil {
+		accounts = append(accounts, admin)
+	}
+	byAge := append([]*models.User(nil), users...)
+	sort.SliceStable(byAge, func(i, j int) bool {
+		return byAge[i].CreatedAt.Before(byAge[j].CreatedAt)
+	})
+	accounts = append(accounts, byAge...)
+
+	index := &emailIndexFile{Emails: make(map[string]string)}
+	for _, account := range accounts {
+		email := models.NormalizeEmail(account.Email)
+		if _, taken := index.Emails[email]; !taken && email != "" {
+			index.Emails[email] = account.ID
+		}
+	}
+	return index
+}
+
+// Driving log operations
+
+// logFile holds one user's driving log. Logs used to be stored inside the
+// user record; a user's log moves to its own file the first time it changes
+// or the user is saved.
+type logFile struct {
+	Entries models.DrivingLog `json:"entries"`
+}
+
+func (s *JSONStorage) logPath(userID string) string {
+	return filepath.Join(s.dataDir, "logs", userID+".json")
+}
+
+// loadLog reads a user's log file, reporting false if they don't have one yet
+func (s *JSONStorage) loadLog(userID string) (models.DrivingLog, bool, error) {
+	var lf logFile
+	if err := s.readFile(s.logPath(userID), &lf); err != nil {
+		if os.IsNotExist(err) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	if lf.Entries == nil {
+		lf.Entries = make(models.DrivingLog)
+	}
+	return lf.Entries, true, nil
+}
+
+// attachLog replaces the log stored in user's record with their log file, if
+// they have one
+func (s *JSONStorage) attachLog(user *models.User) error {
+	log, ok, err := s.loadLog(user.ID)
+	if err != nil {
+		return fmt.Errorf("failed to read log of user %s: %w", user.ID, err)
+	}
+	if ok {
+		user.DrivingLog = log
+	}
+	return nil
+}
+
+// changeLog applies change to a user's log and writes it to their log file,
+// starting from the log in their record if they have no file yet. The caller
+// holds the write lock.
+func (s *JSONStorage) changeLog(userID string, change func(log models.DrivingLog)) error {
+	var user models.User
+	if err := s.readFile(filepath.Join(s.dataDir, "users", userID+".json"), &user); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("user %s not found", userID)
+		}
 		return err
 	}
-	path := filepath.Join(s.dataDir, "sessions.json")
-	return s.writeFile(path, data)
+	if err := s.attachLog(&user); err != nil {
+		return err
+	}
+
+	log := user.DrivingLog
+	if log == nil {
+		log = make(models.DrivingLog)
+	}
+	change(log)
+	return s.writeLog(userID, log)
+}
+
+// writeLog replaces a user's log file. The caller holds the write lock.
+func (s *JSONStorage) writeLog(userID string, log models.DrivingLog) error {
+	return s.writeJSON(s.logPath(userID), logFile{Entries: log})
 }
 
-func (s *JSONStorage) GetSession(token string) (*models.Session, error) {
+func (s *JSONStorage) PutLogEntry(userID, date string, entry models.DayEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.changeLog(userID, func(log models.DrivingLog) {
+		log[date] = entry
+	})
+}
+
+func (s *JSONStorage) DeleteLogEntry(userID, date string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.changeLog(userID, func(log models.DrivingLog) {
+		delete(log, date)
+	})
+}
+
+func (s *JSONStorage) ListLogEntries(userID, from, to string) (models.DrivingLog, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	sf, err := s.loadSessions()
+	log, ok, err := s.loadLog(userID)
 	if err != nil {
 		return nil, err
 	}
+	if !ok {
+		// Not moved out of the user record yet
+		var user models.User
+		if err := s.readFile(filepath.Join(s.dataDir, "users", userID+".json"), &user); err != nil {
+			if os.IsNotExist(err) {
+				return nil, fmt.Errorf("user %s not found", userID)
+			}
+			return nil, err
+		}
+		log = user.DrivingLog
+	}
+
+	// Dates are YYYY-MM-DD, so they compare in calendar order
+	entries := make(models.DrivingLog)
+	for date, entry := range log {
+		if (from == "" || date >= from) && (to == "" || date <= to) {
+			entries[date] = entry
+		}
+	}
+	return entries, nil
+}
 
-	session, exists := sf.Sessions[token]
-	if !exists {
+// Session operations
+
+// Each session is its own file named by its token hash and replaced
+// atomically, so session operations don't take the storage lock: checking a
+// login never waits for other writes, and logins don't rewrite each other.
+// Writes only hold sessionsMu for reading, which keeps them out of backups
+// and bulk rewrites while they run.
+
+func (s *JSONStorage) sessionPath(tokenHash string) (string, bool) {
+	if len(tokenHash) != sha256.Size*2 {
+		return "", false
+	}
+	if _, err := hex.DecodeString(tokenHash); err != nil {
+		return "", false
+	}
+	return filepath.Join(s.dataDir, "sessions", tokenHash+".json"), true
+}
+
+// loadSessionFiles reads every stored session, expired or not
+func (s *JSONStorage) loadSessionFiles() ([]*models.Session, error) {
+	dir := filepath.Join(s.dataDir, "sessions")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []*models.Session
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		var session models.Session
+		if err := s.readFile(filepath.Join(dir, entry.Name()), &session); err != nil {
+			// Removed by a logout or cleanup since the listing
+			continue
+		}
+		sessions = append(sessions, &session)
+	}
+	return sessions, nil
+}
+
+// splitSessions moves sessions from sessions.json, where they were kept by
+// their plain tokens, into hashed session files and removes the file. It
+// returns sessions.json if it exists. The caller holds sessionsMu.
+func (s *JSONStorage) splitSessions(dryRun bool) ([]string, error) {
+	path := filepath.Join(s.dataDir, "sessions.json")
+	var legacy struct {
+		Sessions map[string]struct {
+			UserID    string    `json:"user_id"`
+			ExpiresAt time.Time `json:"expires_at"`
+			CreatedAt time.Time `json:"created_at"`
+		} `json:"sessions"`
+	}
+	if err := s.readFile(path, &legacy); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if dryRun {
+		return []string{"sessions.json"}, nil
+	}
+
+	now := time.Now()
+	for token, old := range legacy.Sessions {
+		if now.After(old.ExpiresAt) {
+			continue
+		}
+		session := &models.Session{
+			TokenHash:  models.HashSessionToken(token),
+			UserID:     old.UserID,
+			ExpiresAt:  old.ExpiresAt,
+			LastSeenAt: old.CreatedAt,
+			CreatedAt:  old.CreatedAt,
+		}
+		sessionPath, _ := s.sessionPath(session.TokenHash)
+		if err := s.writeJSON(sessionPath, session); err != nil {
+			return nil, err
+		}
+	}
+	return []string{"sessions.json"}, os.Remove(path)
+}
+
+func (s *JSONStorage) GetSession(tokenHash string) (*models.Session, error) {
+	path, ok := s.sessionPath(tokenHash)
+	if !ok {
 		return nil, nil
 	}
 
+	var session models.Session
+	if err := s.readFile(path, &session); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
 	if session.IsExpired() {
 		return nil, nil
 	}
 
-	return session, nil
+	return &session, nil
 }
 
 func (s *JSONStorage) SaveSession(session *models.Session) error {
+	s.sessionsMu.RLock()
+	defer s.sessionsMu.RUnlock()
+
+	path, ok := s.sessionPath(session.TokenHash)
+	if !ok {
+		return fmt.Errorf("invalid session token hash")
+	}
+
+	return s.writeJSON(path, session)
+}
+
+func (s *JSONStorage) RefreshSession(session *models.Session) (bool, error) {
+	// Exclusive, so a logout can't remove the file between the check and
+	// the write and have it come back
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+
+	path, ok := s.sessionPath(session.TokenHash)
+	if !ok {
+		return false, fmt.Errorf("invalid session token hash")
+	}
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, s.writeJSON(path, session)
+}
+
+func (s *JSONStorage) DeleteSession(tokenHash string) error {
+	s.sessionsMu.RLock()
+	defer s.sessionsMu.RUnlock()
+
+	return s.removeSession(tokenHash)
+}
+
+// removeSession deletes a session file. The caller holds sessionsMu.
+func (s *JSONStorage) removeSession(tokenHash string) error {
+	path, ok := s.sessionPath(tokenHash)
+	if !ok {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *JSONStorage) CleanExpiredSessions() error {
+	s.sessionsMu.RLock()
+	defer s.sessionsMu.RUnlock()
+
+	sessions, err := s.loadSessionFiles()
+	if err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		if session.IsExpired() {
+			if err := s.removeSession(session.TokenHash); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *JSONStorage) CountSessions() (int, error) {
+	sessions, err := s.loadSessionFiles()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, session := range sessions {
+		if !session.IsExpired() {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Outbox operations
+
+type outboxFile struct {
+	Messages map[string]*models.OutboxMessage `json:"messages"`
+}
+
+func (s *JSONStorage) loadOutbox() (*outboxFile, error) {
+	path := filepath.Join(s.dataDir, "outbox.json")
+	var of outboxFile
+	if err := s.readFile(path, &of); err != nil {
+		if os.IsNotExist(err) {
+			return &outboxFile{Messages: make(map[string]*models.OutboxMessage)}, nil
+		}
+		return nil, err
+	}
+	if of.Messages == nil {
+		of.Messages = make(map[string]*models.OutboxMessage)
+	}
+	return &of, nil
+}
+
+func (s *JSONStorage) saveOutbox(of *outboxFile) error {
+	path := filepath.Join(s.dataDir, "outbox.json")
+	return s.writeJSON(path, of)
+}
+
+func (s *JSONStorage) GetOutboxMessages() ([]*models.OutboxMessage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	of, err := s.loadOutbox()
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]*models.OutboxMessage, 0, len(of.Messages))
+	for _, msg := range of.Messages {
+		messages = append(messages, msg)
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].CreatedAt.Before(messages[j].CreatedAt)
+	})
+	return messages, nil
+}
+
+func (s *JSONStorage) GetOutboxMessage(id string) (*models.OutboxMessage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	of, err := s.loadOutbox()
+	if err != nil {
+		return nil, err
+	}
+	return of.Messages[id], nil
+}
+
+func (s *JSONStorage) SaveOutboxMessage(msg *models.OutboxMessage) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	sf, err := s.loadSessions()
+	of, err := s.loadOutbox()
 	if err != nil {
 		return err
 	}
 
-	sf.Sessions[session.Token] = session
-	return s.saveSessions(sf)
+	of.Messages[msg.ID] = msg
+	return s.saveOutbox(of)
 }
 
-func (s *JSONStorage) DeleteSession(token string) error {
+func (s *JSONStorage) DeleteOutboxMessage(id string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	sf, err := s.loadSessions()
+	of, err := s.loadOutbox()
 	if err != nil {
 		return err
 	}
 
-	delete(sf.Sessions, token)
-	return s.saveSessions(sf)
+	delete(of.Messages, id)
+	return s.saveOutbox(of)
 }
 
-func (s *JSONStorage) CleanExpiredSessions() error {
+// Webhook operations
+
+type webhooksFile struct {
+	Webhooks map[string]*models.Webhook `json:"webhooks"`
+}
+
+type webhookDeliveriesFile struct {
+	Deliveries map[string]*models.WebhookDelivery `json:"deliveries"`
+}
+
+func (s *JSONStorage) loadWebhooks() (*webhooksFile, error) {
+	path := filepath.Join(s.dataDir, "webhooks.json")
+	var wf webhooksFile
+	if err := s.readFile(path, &wf); err != nil {
+		if os.IsNotExist(err) {
+			return &webhooksFile{Webhooks: make(map[string]*models.Webhook)}, nil
+		}
+		return nil, err
+	}
+	if wf.Webhooks == nil {
+		wf.Webhooks = make(map[string]*models.Webhook)
+	}
+	return &wf, nil
+}
+
+func (s *JSONStorage) saveWebhooks(wf *webhooksFile) error {
+	path := filepath.Join(s.dataDir, "webhooks.json")
+	return s.writeJSON(path, wf)
+}
+
+func (s *JSONStorage) loadWebhookDeliveries() (*webhookDeliveriesFile, error) {
+	path := filepath.Join(s.dataDir, "webhook_deliveries.json")
+	var df webhookDeliveriesFile
+	if err := s.readFile(path, &df); err != nil {
+		if os.IsNotExist(err) {
+			return &webhookDeliveriesFile{Deliveries: make(map[string]*models.WebhookDelivery)}, nil
+		}
+		return nil, err
+	}
+	if df.Deliveries == nil {
+		df.Deliveries = make(map[string]*models.WebhookDelivery)
+	}
+	return &df, nil
+}
+
+func (s *JSONStorage) saveWebhookDeliveries(df *webhookDeliveriesFile) error {
+	path := filepath.Join(s.dataDir, "webhook_deliveries.json")
+	return s.writeJSON(path, df)
+}
+
+func (s *JSONStorage) GetWebhooks() ([]*models.Webhook, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	wf, err := s.loadWebhooks()
+	if err != nil {
+		return nil, err
+	}
+
+	webhooks := make([]*models.Webhook, 0, len(wf.Webhooks))
+	for _, webhook := range wf.Webhooks {
+		webhooks = append(webhooks, webhook)
+	}
+	sort.Slice(webhooks, func(i, j int) bool {
+		return webhooks[i].CreatedAt.Before(webhooks[j].CreatedAt)
+	})
+	return webhooks, nil
+}
+
+func (s *JSONStorage) GetWebhook(id string) (*models.Webhook, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	wf, err := s.loadWebhooks()
+	if err != nil {
+		return nil, err
+	}
+	return wf.Webhooks[id], nil
+}
+
+func (s *JSONStorage) SaveWebhook(webhook *models.Webhook) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	sf, err := s.loadSessions()
+	wf, err := s.loadWebhooks()
 	if err != nil {
 		return err
 	}
 
-	for token, session := range sf.Sessions {
-		if session.IsExpired() {
-			delete(sf.Sessions, token)
+	webhook.UpdatedAt = time.Now()
+	wf.Webhooks[webhook.ID] = webhook
+	return s.saveWebhooks(wf)
+}
+
+// DeleteWebhook removes a webhook and its delivery log
+func (s *JSONStorage) DeleteWebhook(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	wf, err := s.loadWebhooks()
+	if err != nil {
+		return err
+	}
+	delete(wf.Webhooks, id)
+	if err := s.saveWebhooks(wf); err != nil {
+		return err
+	}
+
+	df, err := s.loadWebhookDeliveries()
+	if err != nil {
+		return err
+	}
+	for deliveryID, delivery := range df.Deliveries {
+		if delivery.WebhookID == id {
+			delete(df.Deliveries, deliveryID)
+		}
+	}
+	return s.saveWebhookDeliveries(df)
+}
+
+func (s *JSONStorage) GetWebhookDeliveries(webhookID string) ([]*models.WebhookDelivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	df, err := s.loadWebhookDeliveries()
+	if err != nil {
+		return nil, err
+	}
+
+	var deliveries []*models.WebhookDelivery
+	for _, delivery := range df.Deliveries {
+		if webhookID == "" || delivery.WebhookID == webhookID {
+			deliveries = append(deliveries, delivery)
 		}
 	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].CreatedAt.After(deliveries[j].CreatedAt)
+	})
+	return deliveries, nil
+}
+
+func (s *JSONStorage) GetWebhookDelivery(id string) (*models.WebhookDelivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	return s.saveSessions(sf)
+	df, err := s.loadWebhookDeliveries()
+	if err != nil {
+		return nil, err
+	}
+	return df.Deliveries[id], nil
+}
+
+func (s *JSONStorage) SaveWebhookDelivery(delivery *models.WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	df, err := s.loadWebhookDeliveries()
+	if err != nil {
+		return err
+	}
+
+	df.Deliveries[delivery.ID] = delivery
+	return s.saveWebhookDeliveries(df)
+}
+
+func (s *JSONStorage) PruneWebhookDeliveries(cutoff time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	df, err := s.loadWebhookDeliveries()
+	if err != nil {
+		return 0, err
+	}
+
+	pruned := 0
+	for id, delivery := range df.Deliveries {
+		if !delivery.IsPending() && delivery.CreatedAt.Before(cutoff) {
+			delete(df.Deliveries, id)
+			pruned++
+		}
+	}
+	if pruned == 0 {
+		return 0, nil
+	}
+	return pruned, s.saveWebhookDeliveries(df)
 }
 
 // Admin operations
@@ -295,13 +1216,50 @@ func (s *JSONStorage) SaveAdmin(admin *models.User) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	admin.UpdatedAt = time.Now()
+	path := filepath.Join(s.dataDir, "admin.json")
+	return s.saveVersioned(path, admin, false)
+}
 
-	data, err := json.MarshalIndent(admin, "", "  ")
+// saveVersioned writes u to path if the stored copy still has u's version,
+// bumping the version and UpdatedAt. With omitLog the driving log is left
+// out, because it is kept in its own file. The caller holds the write lock.
+func (s *JSONStorage) saveVersioned(path string, u *models.User, omitLog bool) error {
+	var stored struct {
+		Version int64 `json:"version"`
+	}
+	exists := true
+	if err := s.readFile(path, &stored); err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		exists = false
+	}
+	if stored.Version != u.Version || (!exists && u.Version != 0) {
+		return &ConflictError{ID: u.ID, Expected: u.Version, Actual: stored.Version, Deleted: !exists}
+	}
+
+	email := models.NormalizeEmail(u.Email)
+	owner, err := s.emailOwner(email)
 	if err != nil {
 		return err
 	}
+	if owner != "" && owner != u.ID {
+		return &EmailTakenError{Email: email, OwnerID: owner}
+	}
 
-	path := filepath.Join(s.dataDir, "admin.json")
-	return s.writeFile(path, data)
+	// Only bump the caller's copy once the write has succeeded
+	saved := *u
+	saved.Version++
+	saved.Email = email
+	saved.UpdatedAt = time.Now()
+	if omitLog {
+		saved.DrivingLog = nil
+	}
+
+	if err := s.writeJSON(path, &saved); err != nil {
+		return err
+	}
+
+	u.Version, u.Email, u.UpdatedAt = saved.Version, saved.Email, saved.UpdatedAt
+	return s.indexEmail(u.ID, email)
 }
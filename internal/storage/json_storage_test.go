@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"driving-hours/internal/models"
+)
+
+func TestSaveUserWithTakenEmailLeavesNoLog(t *testing.T) {
+	store, err := NewJSONStorage(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	first := &models.User{ID: "first", Email: "driver@example.com", Name: "First", Role: models.RoleDriver, CreatedAt: now}
+	if err := store.SaveUser(first); err != nil {
+		t.Fatal(err)
+	}
+
+	second := &models.User{ID: "second", Email: "Driver@Example.com", Name: "Second", Role: models.RoleDriver, CreatedAt: now}
+	if err := store.SaveUser(second); !errors.Is(err, ErrEmailTaken) {
+		t.Fatalf("SaveUser = %v, want ErrEmailTaken", err)
+	}
+	if _, err := os.Stat(store.logPath(second.ID)); !os.IsNotExist(err) {
+		t.Errorf("log file of the rejected user exists: %v", err)
+	}
+	if _, err := os.Stat(store.logPath(first.ID)); err != nil {
+		t.Errorf("log file of the saved user: %v", err)
+	}
+}
+
+func TestRefreshSessionDoesNotRestoreDeletedSession(t *testing.T) {
+	store, err := NewJSONStorage(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	session := &models.Session{
+		TokenHash:  models.HashSessionToken("token"),
+		UserID:     "driver",
+		ExpiresAt:  now.Add(time.Hour),
+		LastSeenAt: now,
+		CreatedAt:  now,
+	}
+	if err := store.SaveSession(session); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := store.RefreshSession(session); err != nil || !ok {
+		t.Fatalf("RefreshSession = %v, %v, want true", ok, err)
+	}
+
+	if err := store.DeleteSession(session.TokenHash); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := store.RefreshSession(session); err != nil || ok {
+		t.Fatalf("RefreshSession after delete = %v, %v, want false", ok, err)
+	}
+	if got, err := store.GetSession(session.TokenHash); err != nil || got != nil {
+		t.Errorf("GetSession after refresh = %v, %v, want nil", got, err)
+	}
+}
+
+func TestDeleteUserDeletesTheirSessions(t *testing.T) {
+	store, err := NewJSONStorage(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for _, u := range []*models.User{
+		{ID: "purged", Email: "purged@example.com", Name: "Purged", Role: models.RoleDriver, CreatedAt: now},
+		{ID: "kept", Email: "kept@example.com", Name: "Kept", Role: models.RoleDriver, CreatedAt: now},
+	} {
+		if err := store.SaveUser(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sessions := map[string]string{"purged-1": "purged", "purged-2": "purged", "kept-1": "kept"}
+	for token, userID := range sessions {
+		err := store.SaveSession(&models.Session{
+			TokenHash:  models.HashSessionToken(token),
+			UserID:     userID,
+			ExpiresAt:  now.Add(time.Hour),
+			LastSeenAt: now,
+			CreatedAt:  now,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := store.DeleteUser("purged"); err != nil {
+		t.Fatal(err)
+	}
+	for token, userID := range sessions {
+		session, err := store.GetSession(models.HashSessionToken(token))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exists := session != nil; exists != (userID == "kept") {
+			t.Errorf("session %s of %s exists = %v after deleting purged", token, userID, exists)
+		}
+	}
+}
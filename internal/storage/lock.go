@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// LockFileName is the file in the data directory that LockDataDir locks.
+// Backups and restores leave it alone.
+const LockFileName = ".lock"
+
+// ErrLocked is returned by LockDataDir when another process holds the lock
+var ErrLocked = errors.New("data directory is in use by another process")
+
+// DataDirLock is an exclusive lock on a data directory, held by the server
+// while it runs and by operations that replace the directory's contents
+type DataDirLock struct {
+	file *os.File
+}
+
+// LockDataDir takes the lock on dataDir, or returns ErrLocked at once if
+// another process has it
+func LockDataDir(dataDir string) (*DataDirLock, error) {
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return nil, err
+	}
+	file, err := lockFile(filepath.Join(dataDir, LockFileName))
+	if err != nil {
+		return nil, err
+	}
+	return &DataDirLock{file: file}, nil
+}
+
+// Unlock releases the lock
+func (l *DataDirLock) Unlock() error {
+	return unlockFile(l.file)
+}
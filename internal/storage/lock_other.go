@@ -0,0 +1,28 @@
+//go:build !unix
+
+package storage
+
+import (
+	"os"
+)
+
+// lockFile creates path exclusively. Without flock the lock isn't released
+// if the process dies; the file has to be removed by hand then.
+func lockFile(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0600)
+	if err != nil {
+		if os.IsExist(err) {
+			return nil, ErrLocked
+		}
+		return nil, err
+	}
+	return file, nil
+}
+
+func unlockFile(file *os.File) error {
+	err := file.Close()
+	if rmErr := os.Remove(file.Name()); err == nil {
+		err = rmErr
+	}
+	return err
+}
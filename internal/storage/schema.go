@@ -0,0 +1,342 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// schemaField holds the schema version in every stored record. Files from
+// before versioning don't have it and are version 0.
+const schemaField = "schema_version"
+
+// Migration upgrades a stored record by one schema version
+type Migration struct {
+	Description string
+	// Upgrade changes doc, a record decoded with json.Number for numbers, in
+	// place. kind is the record's kind, such as "users" or "outbox": see
+	// recordKind. A nil Upgrade only bumps the version.
+	Upgrade func(kind string, doc map[string]any) error
+	// Restructure changes how records are laid out across files, such as
+	// splitting one file into several, and returns the files it changed, or
+	// would change with dryRun. It only runs from Migrate, never as records
+	// are read, and must change nothing when the data is already laid out in
+	// the new way, since it runs on every migration.
+	Restructure func(s *JSONStorage, dryRun bool) ([]string, error)
+}
+
+// migrations upgrade records one version at a time: migrations[i] takes a
+// record from version i to i+1. New migrations go at the end and old ones are
+// never changed, since records of every older version may still be on disk.
+// They are set in init, since restructuring reads records, which runs them.
+var migrations []Migration
+
+func init() {
+	migrations = []Migration{
+		{
+			Description: "stamp records with their schema version, move sessions into a file each and index emails",
+			Restructure: func(s *JSONStorage, dryRun bool) ([]string, error) {
+				sessions, err := s.splitSessions(dryRun)
+				if err != nil {
+					return nil, err
+				}
+				index, err := s.indexEmails(dryRun)
+				if err != nil {
+					return nil, err
+				}
+				return append(sessions, index...), nil
+			},
+		},
+	}
+}
+
+// SchemaVersion is the version records are written in
+func SchemaVersion() int {
+	return len(migrations)
+}
+
+// ErrNewerSchema matches every *NewerSchemaError with errors.Is
+var ErrNewerSchema = errors.New("record was written by a newer version")
+
+// NewerSchemaError is returned when reading a record in a schema version this
+// build doesn't know, e.g. after downgrading the server
+type NewerSchemaError struct {
+	Version int
+}
+
+func (e *NewerSchemaError) Error() string {
+	return fmt.Sprintf("record has schema version %d, newer than the latest known version %d", e.Version, SchemaVersion())
+}
+
+func (e *NewerSchemaError) Is(target error) bool {
+	return target == ErrNewerSchema
+}
+
+// recordKind names the kind of record stored at path: the directory for
+// per-record files, e.g. "users", or the file name without .json for the
+// files at the top of the data directory, e.g. "admin"
+func (s *JSONStorage) recordKind(path string) string {
+	rel, err := filepath.Rel(s.dataDir, path)
+	if err != nil {
+		return ""
+	}
+	if dir := filepath.Dir(rel); dir != "." {
+		return filepath.ToSlash(dir)
+	}
+	return strings.TrimSuffix(rel, ".json")
+}
+
+// schemaVersion reads the version stamped into a record
+func schemaVersion(data []byte) (int, error) {
+	var header struct {
+		Version int `json:"schema_version"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return 0, err
+	}
+	return header.Version, nil
+}
+
+// upgradeRecord runs the migrations a record needs and returns it stamped
+// with the current version, along with the version it had. Records already
+// current are returned unchanged.
+func upgradeRecord(kind string, data []byte) ([]byte, int, error) {
+	version, err := schemaVersion(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	if version > SchemaVersion() {
+		return nil, version, &NewerSchemaError{Version: version}
+	}
+	if version == SchemaVersion() {
+		return data, version, nil
+	}
+
+	// Without a field to change, only the stamp needs adding
+	pending := migrations[version:]
+	if version == 0 && !slices.ContainsFunc(pending, func(m Migration) bool { return m.Upgrade != nil }) {
+		stamped, err := stampSchema(data)
+		return stamped, version, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc map[string]any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, version, err
+	}
+	for i, m := range pending {
+		if m.Upgrade == nil {
+			continue
+		}
+		if err := m.Upgrade(kind, doc); err != nil {
+			return nil, version, fmt.Errorf("migration to schema version %d: %w", version+i+1, err)
+		}
+	}
+	delete(doc, schemaField)
+
+	upgraded, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, version, err
+	}
+	upgraded, err = stampSchema(upgraded)
+	return upgraded, version, err
+}
+
+// stampSchema adds the current schema version to an encoded record, as the
+// first field so the rest of the file keeps its layout. The record must not
+// have the field already.
+func stampSchema(data []byte) ([]byte, error) {
+	body := bytes.TrimSpace(data)
+	if !bytes.HasPrefix(body, []byte("{")) {
+		return nil, errors.New("record is not a JSON object")
+	}
+	body = bytes.TrimSpace(body[1:])
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "{\n  %q: %d", schemaField, SchemaVersion())
+	if !bytes.Equal(body, []byte("}")) {
+		buf.WriteString(",\n  ")
+	} else {
+		buf.WriteString("\n")
+	}
+	buf.Write(body)
+	return buf.Bytes(), nil
+}
+
+// writeJSON encodes v as a record of the current schema version and writes it
+func (s *JSONStorage) writeJSON(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	data, err = stampSchema(data)
+	if err != nil {
+		return err
+	}
+	return s.writeFile(path, data)
+}
+
+// MigrationReport lists the records a bulk migration upgraded, or would
+// upgrade on a dry run
+type MigrationReport struct {
+	SchemaVersion int
+	Files         int
+	Upgraded      []UpgradedRecord
+	// Restructured lists the files, relative to the data directory, that
+	// were created, rewritten or removed by migrations spanning several
+	// records, and schema.json when it is brought up to date
+	Restructured []string
+}
+
+// schemaPath is the file recording the schema version of the whole data
+// directory, so it can be checked without reading every record
+func (s *JSONStorage) schemaPath() string {
+	return filepath.Join(s.dataDir, "schema.json")
+}
+
+// readSchemaFile returns the version in schema.json, or 0 if there is none
+func (s *JSONStorage) readSchemaFile() (int, error) {
+	data, err := os.ReadFile(s.schemaPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	plain, err := s.cipher.Decrypt(data)
+	if err != nil {
+		return 0, err
+	}
+	return schemaVersion(plain)
+}
+
+func (s *JSONStorage) StoredSchemaVersion() (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.readSchemaFile()
+}
+
+func (s *JSONStorage) InitSchema() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := os.Stat(s.schemaPath()); !os.IsNotExist(err) {
+		return err
+	}
+	paths, err := s.recordFiles()
+	if err != nil || len(paths) > 0 {
+		return err
+	}
+	return s.writeJSON(s.schemaPath(), struct{}{})
+}
+
+// UpgradedRecord is a record a migration applies to
+type UpgradedRecord struct {
+	File string // relative to the data directory
+	From int
+}
+
+// PendingMigrations describes the migrations between version and the current
+// one
+func PendingMigrations(version int) []string {
+	var pending []string
+	for v := max(version, 0); v < SchemaVersion(); v++ {
+		pending = append(pending, fmt.Sprintf("%d: %s", v+1, migrations[v].Description))
+	}
+	return pending
+}
+
+// Migrate rewrites every stored record that isn't in the current schema
+// version. Records are also upgraded in memory whenever they are read and
+// saved in the new version the next time they are written, so this only
+// brings the rest of the files up to date. Changes to how records are laid
+// out across files are only made here. With dryRun nothing is written. A
+// record that can't be read stops the migration; records already rewritten
+// stay upgraded, so it can be run again once the record is fixed.
+func (s *JSONStorage) Migrate(dryRun bool) (*MigrationReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+
+	report := &MigrationReport{SchemaVersion: SchemaVersion()}
+	for i, m := range migrations {
+		if m.Restructure == nil {
+			continue
+		}
+		files, err := m.Restructure(s, dryRun)
+		if err != nil {
+			return report, fmt.Errorf("migration to schema version %d: %w", i+1, err)
+		}
+		report.Restructured = append(report.Restructured, files...)
+	}
+
+	paths, err := s.recordFiles()
+	if err != nil {
+		return report, err
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		if path == s.schemaPath() {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return report, err
+		}
+		report.Files++
+
+		plain, err := s.cipher.Decrypt(data)
+		if err != nil {
+			return report, fmt.Errorf("%s: %w", path, err)
+		}
+		upgraded, from, err := upgradeRecord(s.recordKind(path), plain)
+		if err != nil {
+			return report, fmt.Errorf("%s: %w", path, err)
+		}
+		rel, _ := filepath.Rel(s.dataDir, path)
+		rel = filepath.ToSlash(rel)
+		// Already listed, and rewritten in the current version or removed
+		// when it isn't a dry run
+		if from == SchemaVersion() || slices.Contains(report.Restructured, rel) {
+			continue
+		}
+
+		if !dryRun {
+			if err := s.writeFile(path, upgraded); err != nil {
+				return report, err
+			}
+		}
+		report.Upgraded = append(report.Upgraded, UpgradedRecord{File: rel, From: from})
+	}
+
+	// Last, so an interrupted migration isn't taken for a finished one
+	stored, err := s.readSchemaFile()
+	if err != nil {
+		return report, err
+	}
+	if stored > SchemaVersion() {
+		return report, &NewerSchemaError{Version: stored}
+	}
+	if stored < SchemaVersion() {
+		if !dryRun {
+			if err := s.writeJSON(s.schemaPath(), struct{}{}); err != nil {
+				return report, err
+			}
+		}
+		report.Restructured = append(report.Restructured, "schema.json")
+	}
+	return report, nil
+}
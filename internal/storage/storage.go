@@ -1,26 +1,87 @@
 package storage
 
 import (
+	"time"
+
 	"driving-hours/internal/models"
 )
 
 // Storage defines the interface for data persistence
 type Storage interface {
-	// User operations
+	// User operations. Users carry a Version: SaveUser only writes a user
+	// whose Version matches the stored record (0 for a new user), then
+	// increments it. Otherwise it returns a *ConflictError and writes nothing.
+	// Emails are stored normalized (see models.NormalizeEmail) and are unique
+	// across users and the admin: saving a taken one returns an
+	// *EmailTakenError. GetUserByEmail ignores case and surrounding space.
+	// DeleteUser also deletes the user's log and sessions.
 	GetUser(id string) (*models.User, error)
 	GetUserByEmail(email string) (*models.User, error)
 	GetAllUsers() ([]*models.User, error)
 	GetDrivers() ([]*models.User, error)
+	GetArchivedUsers() ([]*models.User, error)
 	SaveUser(user *models.User) error
 	DeleteUser(id string) error
 
-	// Session operations
-	GetSession(token string) (*models.Session, error)
+	// Driving log operations. A user's log is stored apart from the user:
+	// GetUser fills in DrivingLog, but SaveUser doesn't write it, so the log
+	// is only changed through PutLogEntry and DeleteLogEntry. ListLogEntries
+	// returns the entries dated from from to to inclusive, where an empty
+	// bound is open. Dates are YYYY-MM-DD.
+	PutLogEntry(userID, date string, entry models.DayEntry) error
+	DeleteLogEntry(userID, date string) error
+	ListLogEntries(userID, from, to string) (models.DrivingLog, error)
+
+	// Session operations. Sessions are looked up by TokenHash; the token
+	// itself is never stored. GetSession returns nil for an expired session.
+	// RefreshSession saves a session only if it is still stored, reporting
+	// false if it was deleted, e.g. by a logout.
+	GetSession(tokenHash string) (*models.Session, error)
 	SaveSession(session *models.Session) error
-	DeleteSession(token string) error
+	RefreshSession(session *models.Session) (bool, error)
+	DeleteSession(tokenHash string) error
 	CleanExpiredSessions() error
+	CountSessions() (int, error)
+
+	// Outbox operations. Messages are returned oldest first.
+	GetOutboxMessages() ([]*models.OutboxMessage, error)
+	GetOutboxMessage(id string) (*models.OutboxMessage, error)
+	SaveOutboxMessage(msg *models.OutboxMessage) error
+	DeleteOutboxMessage(id string) error
 
-	// Admin operations
+	// Webhook operations. GetWebhookDeliveries returns the deliveries for one
+	// webhook, or every webhook when webhookID is empty, newest first.
+	// PruneWebhookDeliveries removes finished deliveries created before cutoff.
+	GetWebhooks() ([]*models.Webhook, error)
+	GetWebhook(id string) (*models.Webhook, error)
+	SaveWebhook(webhook *models.Webhook) error
+	DeleteWebhook(id string) error
+	GetWebhookDeliveries(webhookID string) ([]*models.WebhookDelivery, error)
+	GetWebhookDelivery(id string) (*models.WebhookDelivery, error)
+	SaveWebhookDelivery(delivery *models.WebhookDelivery) error
+	PruneWebhookDeliveries(cutoff time.Time) (int, error)
+
+	// Admin operations. SaveAdmin checks Version and Email like SaveUser.
 	GetAdmin() (*models.User, error)
 	SaveAdmin(admin *models.User) error
+
+	// Snapshot runs fn while no writes can happen, for consistent backups
+	Snapshot(fn func() error) error
+
+	// Ping checks that the store can be read and written
+	Ping() error
+
+	// CheckIntegrity validates every stored record, fixing what can be fixed
+	// safely when repair is set
+	CheckIntegrity(repair bool) (*IntegrityReport, error)
+
+	// Migrate rewrites every record stored in an older schema version in the
+	// current one, or only reports them when dryRun is set
+	Migrate(dryRun bool) (*MigrationReport, error)
+
+	// StoredSchemaVersion is the schema version the last complete Migrate
+	// brought every record to, without reading the records; 0 if it never
+	// ran. InitSchema sets it to the current version in a new, empty store.
+	StoredSchemaVersion() (int, error)
+	InitSchema() error
 }
@@ -0,0 +1,146 @@
+package templates
+
+import (
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"driving-hours/internal/i18n"
+	"driving-hours/internal/logging"
+)
+
+// errorPage is standalone so it still works when the layout itself is broken
+var errorPage = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+    <meta charset="UTF-8">
+    <title>{{if .Dev}}Template error{{else}}{{.Heading}}{{end}} - {{.AppName}}</title>
+    <style>
+        body { font-family: system-ui, sans-serif; margin: 2rem auto; max-width: 60rem; padding: 0 1rem; color: #1f2937; }
+        h1 { color: #b91c1c; }
+        pre { background: #f3f4f6; padding: 1rem; overflow-x: auto; white-space: pre-wrap; }
+        .current { background: #fee2e2; display: block; }
+        th { text-align: left; padding-right: 2rem; }
+        .muted { color: #6b7280; }
+    </style>
+</head>
+<body>
+{{if .Dev}}
+    <h1>Template error</h1>
+    <p>Rendering <strong>{{.Page}}</strong> failed{{if .File}} in <strong>{{.File}}</strong>{{if .Line}} at line <strong>{{.Line}}</strong>{{end}}{{end}}.</p>
+    <pre>{{.Error}}</pre>
+    {{if .Source}}
+    <h2>Source</h2>
+    <pre>{{range .Source}}<span{{if .Current}} class="current"{{end}}>{{printf "%4d" .Number}}  {{.Text}}</span>
+{{end}}</pre>
+    {{end}}
+    <h2>Data keys</h2>
+    <table>
+        {{range .Keys}}<tr><th>{{.Name}}</th><td class="muted">{{.Type}}</td></tr>{{else}}<tr><td class="muted">No data</td></tr>{{end}}
+    </table>
+{{else}}
+    <h1>{{.Heading}}</h1>
+    <p>{{.Message}}</p>
+{{end}}
+    {{if .RequestID}}<p class="muted">{{.RequestIDLabel}}: {{.RequestID}}</p>{{end}}
+</body>
+</html>
+`))
+
+// errorLocation matches the "name:line" that text/template and html/template
+// put at the start of their errors, e.g. "template: dashboard.html:23:12: ..."
+var errorLocation = regexp.MustCompile(`template:\s?([^:\s]+):(\d+)`)
+
+type sourceLine struct {
+	Number  int
+	Text    string
+	Current bool
+}
+
+type dataKey struct {
+	Name string
+	Type string
+}
+
+// renderError logs a failed render and responds with a 500 error page. In
+// development mode the page shows the error, its location and the data keys.
+func (r *Renderer) renderError(w http.ResponseWriter, req *http.Request, page string, data Data, err error) {
+	logging.FromContext(req.Context()).Error("failed to render template", "template", page, "error", err)
+
+	// The developer details stay in English; what users see is translated
+	loc := i18n.FromContext(req.Context())
+	view := map[string]any{
+		"Dev":            r.dev,
+		"Lang":           loc.Tag,
+		"AppName":        loc.T("Driving Hours"),
+		"Heading":        loc.T("Something went wrong"),
+		"Message":        loc.T("The page could not be displayed. Please try again."),
+		"RequestID":      logging.RequestID(req.Context()),
+		"RequestIDLabel": loc.T("Request ID"),
+	}
+
+	if r.dev {
+		view["Page"] = page
+		view["Error"] = err.Error()
+
+		if m := errorLocation.FindStringSubmatch(err.Error()); m != nil {
+			line, _ := strconv.Atoi(m[2])
+			file := r.findSource(page, m[1])
+			view["File"] = file
+			view["Line"] = line
+			view["Source"] = r.sourceExcerpt(file, line)
+		}
+
+		keys := make([]dataKey, 0, len(data))
+		for name, value := range data {
+			keys = append(keys, dataKey{Name: name, Type: fmt.Sprintf("%T", value)})
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i].Name < keys[j].Name })
+		view["Keys"] = keys
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusInternalServerError)
+	errorPage.Execute(w, view)
+}
+
+// findSource maps the base file name used in template errors back to a path
+// in the template directory, preferring the page being rendered
+func (r *Renderer) findSource(page, base string) string {
+	if path.Base(page) == base {
+		return page
+	}
+	for _, dir := range []string{"layouts", "partials"} {
+		candidate := path.Join(dir, base)
+		if _, err := fs.Stat(r.fsys, candidate); err == nil {
+			return candidate
+		}
+	}
+	return base
+}
+
+// sourceExcerpt returns a few lines either side of line in file
+func (r *Renderer) sourceExcerpt(file string, line int) []sourceLine {
+	if line <= 0 {
+		return nil
+	}
+
+	content, err := fs.ReadFile(r.fsys, file)
+	if err != nil {
+		return nil
+	}
+
+	lines := strings.Split(string(content), "\n")
+	var excerpt []sourceLine
+	for n := max(1, line-3); n <= min(len(lines), line+3); n++ {
+		excerpt = append(excerpt, sourceLine{Number: n, Text: lines[n-1], Current: n == line})
+	}
+	return excerpt
+}
@@ -1,40 +1,100 @@
 package templates
 
 import (
+	"bytes"
 	"fmt"
 	"html/template"
 	"io/fs"
+	"log/slog"
 	"net/http"
-	"path/filepath"
+	"path"
 	"strings"
+	"sync"
 	"time"
 
+	"driving-hours/internal/assets"
+	"driving-hours/internal/i18n"
 	"driving-hours/internal/middleware"
 	"driving-hours/internal/models"
+	"driving-hours/internal/utils"
 )
 
 type Data map[string]interface{}
 
 type Renderer struct {
-	templates map[string]*template.Template
+	fsys    fs.FS
+	funcMap template.FuncMap
+	dev     bool
+
+	mu sync.RWMutex
+	// templates holds one set per locale tag, then per page
+	templates map[string]map[string]*template.Template
+	signature string // fingerprint of the template files, checked in dev mode
+	parseErr  error  // the last failed reload in dev mode
 }
 
-func NewRenderer(templatesDir string) (*Renderer, error) {
-	templates := make(map[string]*template.Template)
+// NewRenderer parses every page in fsys together with the base layout and
+// partials, once per locale so that t and the format functions translate
+// into the request's language. In development mode templates are re-parsed whenever a file in
+// fsys changes, and render errors show their details in the browser.
+func NewRenderer(fsys fs.FS, static *assets.Assets, dev bool) (*Renderer, error) {
 	funcMap := createFuncMap()
+	funcMap["static"] = static.URL
+
+	r := &Renderer{fsys: fsys, funcMap: funcMap, dev: dev}
+
+	templates, err := r.parse()
+	if err != nil {
+		return nil, err
+	}
+	r.templates = templates
+
+	if dev {
+		r.signature, err = fileSignature(fsys)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// parse builds one template set per page for every locale
+func (r *Renderer) parse() (map[string]map[string]*template.Template, error) {
+	templates := make(map[string]map[string]*template.Template)
+	for _, loc := range i18n.Available() {
+		pages, err := r.parseLocale(loc)
+		if err != nil {
+			return nil, err
+		}
+		templates[loc.Tag] = pages
+	}
+	return templates, nil
+}
+
+// parseLocale builds one template set per page with loc's functions
+func (r *Renderer) parseLocale(loc *i18n.Locale) (map[string]*template.Template, error) {
+	templates := make(map[string]*template.Template)
+
+	funcMap := make(template.FuncMap, len(r.funcMap))
+	for name, fn := range r.funcMap {
+		funcMap[name] = fn
+	}
+	for name, fn := range localeFuncMap(loc) {
+		funcMap[name] = fn
+	}
 
 	// Parse base layout
-	baseLayout := filepath.Join(templatesDir, "layouts", "base.html")
+	baseLayout := "layouts/base.html"
 
 	// Parse partials
-	partialsGlob := filepath.Join(templatesDir, "partials", "*.html")
-	partials, err := filepath.Glob(partialsGlob)
+	partials, err := fs.Glob(r.fsys, "partials/*.html")
 	if err != nil {
 		return nil, fmt.Errorf("failed to glob partials: %w", err)
 	}
 
 	// Walk through page templates
-	err = filepath.WalkDir(templatesDir, func(path string, d fs.DirEntry, err error) error {
+	err = fs.WalkDir(r.fsys, ".", func(name string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
@@ -44,26 +104,25 @@ func NewRenderer(templatesDir string) (*Renderer, error) {
 		}
 
 		// Skip layouts and partials
-		rel, _ := filepath.Rel(templatesDir, path)
-		if strings.HasPrefix(rel, "layouts") || strings.HasPrefix(rel, "partials") {
+		if strings.HasPrefix(name, "layouts/") || strings.HasPrefix(name, "partials/") {
 			return nil
 		}
 
-		if !strings.HasSuffix(path, ".html") {
+		if !strings.HasSuffix(name, ".html") {
 			return nil
 		}
 
 		// Create template with base layout, partials, and page
 		files := append([]string{baseLayout}, partials...)
-		files = append(files, path)
+		files = append(files, name)
 
-		tmpl, err := template.New(filepath.Base(baseLayout)).Funcs(funcMap).ParseFiles(files...)
+		tmpl, err := template.New(path.Base(baseLayout)).Funcs(funcMap).ParseFS(r.fsys, files...)
 		if err != nil {
-			return fmt.Errorf("failed to parse template %s: %w", path, err)
+			return fmt.Errorf("failed to parse template %s: %w", name, err)
 		}
 
 		// Use relative path as key (e.g., "auth/login.html")
-		templates[rel] = tmpl
+		templates[name] = tmpl
 		return nil
 	})
 
@@ -71,46 +130,142 @@ func NewRenderer(templatesDir string) (*Renderer, error) {
 		return nil, err
 	}
 
-	return &Renderer{templates: templates}, nil
+	return templates, nil
 }
 
-func (r *Renderer) Render(w http.ResponseWriter, req *http.Request, name string, data Data) {
-	tmpl, ok := r.templates[name]
-	if !ok {
-		http.Error(w, fmt.Sprintf("template %s not found", name), http.StatusInternalServerError)
-		return
+// fileSignature fingerprints the names, sizes and modification times of every
+// file in fsys, so a change to any of them can be detected cheaply
+func fileSignature(fsys fs.FS) (string, error) {
+	var b strings.Builder
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&b, "%s:%d:%d;", name, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	return b.String(), err
+}
+
+// reloadIfChanged re-parses the templates when a file has changed since the
+// last parse. A failed parse keeps the error so it can be shown until fixed.
+func (r *Renderer) reloadIfChanged() error {
+	signature, err := fileSignature(r.fsys)
+	if err != nil {
+		return err
 	}
 
+	r.mu.RLock()
+	unchanged := signature == r.signature
+	parseErr := r.parseErr
+	r.mu.RUnlock()
+	if unchanged {
+		return parseErr
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if signature == r.signature {
+		return r.parseErr
+	}
+
+	templates, err := r.parse()
+	r.signature = signature
+	r.parseErr = err
+	if err != nil {
+		slog.Warn("template reload failed", "error", err)
+		return err
+	}
+
+	r.templates = templates
+	slog.Info("templates reloaded", "locales", len(templates))
+	return nil
+}
+
+// Ready reports whether page templates have been loaded
+func (r *Renderer) Ready() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.templates) > 0
+}
+
+var bufferPool = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
+}
+
+// Render executes a page into a buffer and only writes it once it has
+// rendered completely, so a failure never leaves a half-sent page
+func (r *Renderer) Render(w http.ResponseWriter, req *http.Request, name string, data Data) {
 	// Add CSRF token to all templates
 	if data == nil {
 		data = make(Data)
 	}
 	data["CSRFField"] = template.HTML(middleware.CSRFTemplateField(req))
+	data["CSPNonce"] = middleware.CSPNonce(req)
+	loc := i18n.FromContext(req.Context())
+
+	if r.dev {
+		if err := r.reloadIfChanged(); err != nil {
+			r.renderError(w, req, name, data, err)
+			return
+		}
+	}
+
+	r.mu.RLock()
+	tmpl, ok := r.templates[loc.Tag][name]
+	r.mu.RUnlock()
+	if !ok {
+		r.renderError(w, req, name, data, fmt.Errorf("template %s not found", name))
+		return
+	}
+
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer bufferPool.Put(buf)
+
+	if err := tmpl.ExecuteTemplate(buf, "base", data); err != nil {
+		r.renderError(w, req, name, data, err)
+		return
+	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	if err := tmpl.ExecuteTemplate(w, "base", data); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	buf.WriteTo(w)
+}
+
+// localeFuncMap holds the functions whose output depends on the language.
+// Template text is translated with t, e.g. {{t "Save"}} or
+// {{t "Welcome, %s" .User.Name}}, and counts with tn, e.g.
+// {{tn "%d users" (len .Users)}}.
+func localeFuncMap(loc *i18n.Locale) template.FuncMap {
+	return template.FuncMap{
+		"t":              loc.T,
+		"tn":             loc.N,
+		"lang":           func() string { return loc.Tag },
+		"monthName":      func(month int) string { return loc.MonthName(time.Month(month)) },
+		"weekdayShort":   func(day int) string { return loc.WeekdayShort(time.Weekday(day)) },
+		"formatDate":     loc.FormatDate,
+		"formatDateTime": loc.FormatDateTime,
+		"formatHours":    loc.FormatHours,
+		"formatDecimal":  loc.FormatDecimal,
 	}
 }
 
 func createFuncMap() template.FuncMap {
 	return template.FuncMap{
-		"formatDate": func(t time.Time) string {
-			return t.Format("Jan 2, 2006")
-		},
-		"formatDateTime": func(t time.Time) string {
-			return t.Format("Jan 2, 2006 3:04 PM")
-		},
-		"formatHours": func(h float64) string {
-			hours := int(h)
-			minutes := int((h - float64(hours)) * 60)
-			if minutes == 0 {
-				return fmt.Sprintf("%dh", hours)
+		"formatBytes": func(n int64) string {
+			switch {
+			case n >= 1<<20:
+				return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+			case n >= 1<<10:
+				return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+			default:
+				return fmt.Sprintf("%d B", n)
 			}
-			return fmt.Sprintf("%dh %dm", hours, minutes)
-		},
-		"formatDecimal": func(h float64) string {
-			return fmt.Sprintf("%.2f", h)
 		},
 		"percentage": func(current, required float64) float64 {
 			if required <= 0 {
@@ -131,6 +286,15 @@ func createFuncMap() template.FuncMap {
 		"sub": func(a, b int) int {
 			return a - b
 		},
+		"timezones": func() []string {
+			return utils.CommonTimezones
+		},
+		"defaultTimezone": func() string {
+			return models.DefaultLocation().String()
+		},
+		"locales": func() []*i18n.Locale {
+			return i18n.Available()
+		},
 		"isAdmin": func(user *models.User) bool {
 			return user != nil && user.IsAdmin()
 		},
@@ -151,6 +315,7 @@ func createFuncMap() template.FuncMap {
 			}
 			return d
 		},
+		"join": strings.Join,
 		"seq": func(start, end int) []int {
 			var result []int
 			for i := start; i <= end; i++ {
@@ -0,0 +1,35 @@
+package tlscert
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// RedirectHandler sends every request to the same host and path over HTTPS
+// on httpsPort. Paths registered on passthrough (such as health checks) are
+// served directly instead.
+func RedirectHandler(httpsPort int, passthrough map[string]http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h, ok := passthrough[r.URL.Path]; ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.Trim(host, "[]") // bare IPv6 literal
+
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	})
+}
@@ -0,0 +1,139 @@
+package tlscert
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Reloader serves a certificate and key pair from disk and swaps in a new
+// pair when asked to reload or when the files change. A failed reload keeps
+// the previous certificate, so a half-written renewal never takes the site down.
+type Reloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time // latest modification time of the two files when loaded
+}
+
+// NewReloader loads the certificate and key, failing if they are unusable
+func NewReloader(certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload reads the certificate and key from disk again
+func (r *Reloader) Reload() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("failed to parse certificate: %w", err)
+	}
+	cert.Leaf = leaf
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.modTime = modTime
+	r.mu.Unlock()
+
+	slog.Info("TLS certificate loaded",
+		"subject", leaf.Subject.CommonName,
+		"dns_names", leaf.DNSNames,
+		"expires", leaf.NotAfter,
+	)
+	if time.Until(leaf.NotAfter) < 14*24*time.Hour {
+		slog.Warn("TLS certificate expires soon", "expires", leaf.NotAfter)
+	}
+	return nil
+}
+
+// Watch polls the certificate files every interval and reloads them when
+// either changes, until ctx is cancelled. A change that fails to load is
+// not retried until the files change again.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	r.mu.RLock()
+	lastSeen := r.modTime
+	r.mu.RUnlock()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			modTime, err := r.latestModTime()
+			if err != nil {
+				slog.Warn("failed to check TLS certificate", "error", err)
+				continue
+			}
+
+			if modTime.After(lastSeen) {
+				lastSeen = modTime
+				if err := r.Reload(); err != nil {
+					slog.Error("TLS certificate reload failed, keeping the current certificate", "error", err)
+				}
+			}
+		}
+	}
+}
+
+func (r *Reloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Config returns a server TLS configuration with modern defaults that serves
+// the reloader's current certificate
+func (r *Reloader) Config() *tls.Config {
+	return &tls.Config{
+		MinVersion:       tls.VersionTLS12,
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+		// Only forward-secret AEAD suites for TLS 1.2; TLS 1.3 suites are not configurable
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+		},
+		GetCertificate: r.GetCertificate,
+	}
+}
@@ -4,9 +4,10 @@ import (
 	"time"
 )
 
-// GetGreeting returns a time-based greeting
-func GetGreeting() string {
-	hour := time.Now().Hour()
+// GetGreeting returns a greeting for the time of day of now, which should
+// be in the user's timezone
+func GetGreeting(now time.Time) string {
+	hour := now.Hour()
 
 	switch {
 	case hour >= 5 && hour < 12:
@@ -42,21 +43,23 @@ type CalendarData struct {
 	Days      []CalendarDay
 }
 
-// GetCalendarData generates calendar data for the given month and year
-func GetCalendarData(year, month int, hasEntry func(string) bool, getEntry func(string) interface{}) CalendarData {
+// GetCalendarData generates calendar data for the given month and year.
+// now is the current time in the user's timezone; it decides which day is
+// today and the month shown when month is out of range.
+func GetCalendarData(year, month int, now time.Time, hasEntry func(string) bool, getEntry func(string) interface{}) CalendarData {
 	if month < 1 || month > 12 {
-		now := time.Now()
 		year = now.Year()
 		month = int(now.Month())
 	}
 
-	firstOfMonth := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.Local)
+	loc := now.Location()
+	firstOfMonth := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, loc)
 	lastOfMonth := firstOfMonth.AddDate(0, 1, -1)
-	today := time.Now().Format("2006-01-02")
+	today := now.Format("2006-01-02")
 
 	// Calculate previous and next month
-	prevMonth := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.Local).AddDate(0, -1, 0)
-	nextMonth := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.Local).AddDate(0, 1, 0)
+	prevMonth := firstOfMonth.AddDate(0, -1, 0)
+	nextMonth := firstOfMonth.AddDate(0, 1, 0)
 
 	// Start from the Sunday of the week containing the first day
 	startDay := firstOfMonth
@@ -106,3 +109,23 @@ func GetCalendarData(year, month int, hasEntry func(string) bool, getEntry func(
 		Days:      days,
 	}
 }
+
+// CommonTimezones are suggested in timezone fields; any IANA name is accepted
+var CommonTimezones = []string{
+	"America/New_York",
+	"America/Chicago",
+	"America/Denver",
+	"America/Phoenix",
+	"America/Los_Angeles",
+	"America/Anchorage",
+	"Pacific/Honolulu",
+	"America/Puerto_Rico",
+	"America/Toronto",
+	"America/Vancouver",
+	"America/Mexico_City",
+	"Europe/London",
+	"Europe/Madrid",
+	"Europe/Berlin",
+	"Australia/Sydney",
+	"UTC",
+}
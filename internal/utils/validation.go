@@ -3,6 +3,9 @@ package utils
 import (
 	"regexp"
 	"strings"
+	"time"
+
+	"driving-hours/internal/i18n"
 )
 
 var emailRegex = regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
@@ -47,7 +50,11 @@ func ValidateDate(date string) bool {
 		return false
 	}
 	dateRegex := regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
-	return dateRegex.MatchString(date)
+	if !dateRegex.MatchString(date) {
+		return false
+	}
+	_, err := time.Parse("2006-01-02", date)
+	return err == nil
 }
 
 // ValidateHours checks if hours value is reasonable
@@ -60,3 +67,24 @@ func ValidateHours(hours float64) (bool, string) {
 	}
 	return true, ""
 }
+
+// ValidateTimezone checks that a timezone is empty (use the default) or a
+// known IANA name such as "America/Chicago"
+func ValidateTimezone(name string) (bool, string) {
+	if name == "" {
+		return true, ""
+	}
+	if _, err := time.LoadLocation(name); err != nil || name == "Local" {
+		return false, "Unknown timezone"
+	}
+	return true, ""
+}
+
+// ValidateLocale checks that a locale is empty (follow the browser) or one
+// that has a message catalog
+func ValidateLocale(tag string) (bool, string) {
+	if tag == "" || i18n.Supported(tag) {
+		return true, ""
+	}
+	return false, "Unsupported language"
+}
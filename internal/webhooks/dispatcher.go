@@ -0,0 +1,352 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+
+	"driving-hours/internal/metrics"
+	"driving-hours/internal/models"
+	"driving-hours/internal/retry"
+)
+
+// maxResponse is how much of an endpoint's response is kept in the delivery log
+const maxResponse = 1024
+
+// Store is the storage the dispatcher reads webhooks from and logs deliveries to
+type Store interface {
+	GetWebhooks() ([]*models.Webhook, error)
+	GetWebhook(id string) (*models.Webhook, error)
+	GetWebhookDeliveries(webhookID string) ([]*models.WebhookDelivery, error)
+	GetWebhookDelivery(id string) (*models.WebhookDelivery, error)
+	SaveWebhookDelivery(delivery *models.WebhookDelivery) error
+}
+
+// Options configures a Dispatcher
+type Options struct {
+	// MaxAttempts is how many times a delivery is tried before it is abandoned
+	MaxAttempts int
+	// RetryDelay is the wait after the first failure; it doubles after each
+	// further failure, up to a day
+	RetryDelay time.Duration
+	// Timeout limits each request, including reading the response
+	Timeout time.Duration
+}
+
+// Dispatcher sends events to the webhooks subscribed to them. Each delivery
+// is logged in the store before it is attempted, and failed deliveries are
+// retried by Flush with a growing delay.
+type Dispatcher struct {
+	store   Store
+	client  *http.Client
+	opts    Options
+	retries *retry.Queue
+}
+
+func New(store Store, opts Options) *Dispatcher {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+
+	return &Dispatcher{
+		store: store,
+		client: &http.Client{
+			Timeout: opts.Timeout,
+			// A redirect would resend the payload somewhere the admin didn't configure
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		opts:    opts,
+		retries: retry.New(opts.MaxAttempts, opts.RetryDelay),
+	}
+}
+
+// Publish queues event for every active webhook subscribed to it and starts
+// delivering in the background. An error means nothing was queued.
+func (d *Dispatcher) Publish(ctx context.Context, event string, data any) error {
+	webhooks, err := d.store.GetWebhooks()
+	if err != nil {
+		return err
+	}
+
+	var payload []byte
+	for _, webhook := range webhooks {
+		if !webhook.Subscribed(event) {
+			continue
+		}
+
+		// Every webhook gets the same event ID
+		if payload == nil {
+			payload, err = json.Marshal(Envelope{
+				ID:        uuid.New().String(),
+				Event:     event,
+				CreatedAt: time.Now().UTC(),
+				Data:      data,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to encode %s payload: %w", event, err)
+			}
+		}
+
+		delivery, err := d.queue(webhook.ID, event, string(payload))
+		if err != nil {
+			return err
+		}
+
+		d.retries.Go(ctx, func(ctx context.Context) {
+			d.deliver(ctx, delivery)
+		})
+	}
+	return nil
+}
+
+// PublishLogChange publishes the entry event for a change to driver's hours on
+// date, and requirement.completed if the change finished their requirements.
+// before is the entry as it was and wasMet whether requirements were met then.
+func (d *Dispatcher) PublishLogChange(ctx context.Context, driver *models.User, date string, before models.DayEntry, wasMet bool) error {
+	hadEntry := before.DayHours > 0 || before.NightHours > 0
+	after := driver.DrivingLog.GetEntry(date)
+	hasEntry := driver.DrivingLog.HasEntry(date)
+
+	data := EntryData{
+		User:       userData(driver),
+		Date:       date,
+		DayHours:   after.DayHours,
+		NightHours: after.NightHours,
+	}
+	if hadEntry {
+		data.Previous = &before
+	}
+
+	var event string
+	switch {
+	case !hadEntry && hasEntry:
+		event = EntryCreated
+	case hadEntry && !hasEntry:
+		event = EntryDeleted
+	case hadEntry && after != before:
+		event = EntryUpdated
+	}
+	if event != "" {
+		if err := d.Publish(ctx, event, data); err != nil {
+			return err
+		}
+	}
+
+	if !wasMet && driver.RequirementsMet() {
+		return d.Publish(ctx, RequirementCompleted, RequirementData{
+			User:            userData(driver),
+			TotalDayHours:   driver.TotalDayHours(),
+			TotalNightHours: driver.TotalNightHours(),
+		})
+	}
+	return nil
+}
+
+// PublishUserCreated publishes user.created
+func (d *Dispatcher) PublishUserCreated(ctx context.Context, u *models.User) error {
+	return d.Publish(ctx, UserCreated, userData(u))
+}
+
+// Test sends a ping to a webhook, whatever its events and even if it is
+// inactive, and waits for the result
+func (d *Dispatcher) Test(ctx context.Context, webhookID string) (*models.WebhookDelivery, error) {
+	payload, err := json.Marshal(Envelope{
+		ID:        uuid.New().String(),
+		Event:     Ping,
+		CreatedAt: time.Now().UTC(),
+		Data:      map[string]string{"webhook_id": webhookID},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	delivery, err := d.queue(webhookID, Ping, string(payload))
+	if err != nil {
+		return nil, err
+	}
+	d.deliver(ctx, delivery)
+	return delivery, nil
+}
+
+// Redeliver sends a logged delivery's payload again as a new delivery and
+// waits for the result
+func (d *Dispatcher) Redeliver(ctx context.Context, deliveryID string) (*models.WebhookDelivery, error) {
+	original, err := d.store.GetWebhookDelivery(deliveryID)
+	if err != nil {
+		return nil, err
+	}
+	if original == nil {
+		return nil, fmt.Errorf("delivery %s not found", deliveryID)
+	}
+
+	delivery, err := d.queue(original.WebhookID, original.Event, original.Payload)
+	if err != nil {
+		return nil, err
+	}
+	d.deliver(ctx, delivery)
+	return delivery, nil
+}
+
+func (d *Dispatcher) queue(webhookID, event, payload string) (*models.WebhookDelivery, error) {
+	now := time.Now()
+	delivery := &models.WebhookDelivery{
+		ID:            uuid.New().String(),
+		WebhookID:     webhookID,
+		Event:         event,
+		Payload:       payload,
+		CreatedAt:     now,
+		NextAttemptAt: now,
+	}
+	if err := d.store.SaveWebhookDelivery(delivery); err != nil {
+		return nil, fmt.Errorf("failed to queue webhook delivery: %w", err)
+	}
+	return delivery, nil
+}
+
+// Flush attempts every pending delivery that is due, one at a time
+func (d *Dispatcher) Flush(ctx context.Context) error {
+	deliveries, err := d.store.GetWebhookDeliveries("")
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	attempted, failed := 0, 0
+	// Oldest first, so events arrive in the order they happened
+	for i := len(deliveries) - 1; i >= 0; i-- {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !deliveries[i].IsDue(now) {
+			continue
+		}
+		attempted++
+		if err := d.deliver(ctx, deliveries[i]); err != nil {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d webhook deliveries failed", failed, attempted)
+	}
+	return nil
+}
+
+// Wait blocks until background deliveries started by Publish have finished
+func (d *Dispatcher) Wait() {
+	d.retries.Wait()
+}
+
+// deliver makes one attempt at a delivery and records the outcome on it.
+// delivery is refreshed from the store first, since the copy the caller holds
+// may have been delivered or rescheduled since it was read.
+func (d *Dispatcher) deliver(ctx context.Context, delivery *models.WebhookDelivery) error {
+	reload := func(now time.Time) (bool, error) {
+		current, err := d.store.GetWebhookDelivery(delivery.ID)
+		if err != nil || current == nil || !current.IsDue(now) {
+			// Already delivered, abandoned, pruned or waiting for a later retry
+			return false, err
+		}
+		*delivery = *current
+		return true, nil
+	}
+	return d.retries.Attempt(delivery.ID, reload, func() error {
+		return d.attempt(ctx, delivery)
+	})
+}
+
+// attempt sends a delivery to its webhook and logs the outcome, scheduling a
+// retry if it failed
+func (d *Dispatcher) attempt(ctx context.Context, delivery *models.WebhookDelivery) error {
+	logger := slog.With("delivery_id", delivery.ID, "webhook_id", delivery.WebhookID, "event", delivery.Event)
+
+	webhook, err := d.store.GetWebhook(delivery.WebhookID)
+	switch {
+	case err != nil:
+		// Retried like a failed send
+	case webhook == nil:
+		err = fmt.Errorf("webhook %s no longer exists", delivery.WebhookID)
+		delivery.Abandoned = true
+	case !webhook.Active && delivery.Event != Ping:
+		// Disabled since the event was published; a ping tests it regardless
+		err = fmt.Errorf("webhook %s is inactive", delivery.WebhookID)
+		delivery.Abandoned = true
+	default:
+		err = d.send(ctx, webhook, delivery)
+	}
+
+	now := time.Now()
+	delivery.Attempts++
+	delivery.LastAttemptAt = &now
+
+	if err == nil {
+		delivery.DeliveredAt = &now
+		delivery.Error = ""
+		metrics.WebhookDeliveries.Inc(delivery.Event, "delivered")
+		logger.Info("webhook delivered", "status", delivery.StatusCode)
+	} else {
+		delivery.Error = err.Error()
+		next, abandon := d.retries.Failed(delivery.Attempts, now)
+		// A ping checks the endpoint now, so it isn't retried later
+		if delivery.Abandoned || delivery.Event == Ping || abandon {
+			delivery.Abandoned = true
+			metrics.WebhookDeliveries.Inc(delivery.Event, "abandoned")
+			logger.Error("webhook delivery abandoned", "attempts", delivery.Attempts, "error", err)
+		} else {
+			delivery.NextAttemptAt = next
+			metrics.WebhookDeliveries.Inc(delivery.Event, "failed")
+			logger.Warn("webhook delivery failed, will retry", "attempts", delivery.Attempts, "next_attempt", delivery.NextAttemptAt, "error", err)
+		}
+	}
+
+	if saveErr := d.store.SaveWebhookDelivery(delivery); saveErr != nil {
+		logger.Error("failed to update webhook delivery log", "error", saveErr)
+	}
+	return err
+}
+
+// send posts the payload and records the response on delivery. Any status
+// other than 2xx is a failure.
+func (d *Dispatcher) send(ctx context.Context, webhook *models.Webhook, delivery *models.WebhookDelivery) error {
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "DrivingHours-Webhooks/1.0")
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderDelivery, delivery.ID)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(webhook.Secret, timestamp, body))
+
+	delivery.StatusCode = 0
+	delivery.Response = ""
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	response, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponse))
+	delivery.StatusCode = resp.StatusCode
+	delivery.Response = string(response)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("endpoint responded %s", resp.Status)
+	}
+	return nil
+}
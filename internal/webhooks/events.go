@@ -0,0 +1,96 @@
+package webhooks
+
+import (
+	"time"
+
+	"driving-hours/internal/models"
+)
+
+// Event types a webhook can subscribe to
+const (
+	EntryCreated         = "entry.created"
+	EntryUpdated         = "entry.updated"
+	EntryDeleted         = "entry.deleted"
+	UserCreated          = "user.created"
+	RequirementCompleted = "requirement.completed"
+
+	// Ping is sent by Test to check an endpoint; it can't be subscribed to
+	Ping = "ping"
+)
+
+// EventType describes an event for the webhook form. Descriptions are
+// English message keys.
+type EventType struct {
+	Name        string
+	Description string
+}
+
+// Events lists the event types in the order they are shown
+var Events = []EventType{
+	{EntryCreated, "A driver logged hours for a new date"},
+	{EntryUpdated, "Hours for a date were changed"},
+	{EntryDeleted, "Hours for a date were removed"},
+	{UserCreated, "A user was created"},
+	{RequirementCompleted, "A driver logged all of their required hours"},
+}
+
+// IsEvent reports whether name is an event type that can be subscribed to
+func IsEvent(name string) bool {
+	for _, e := range Events {
+		if e.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Envelope is the JSON body of every delivery. ID identifies the event, so a
+// receiver can recognise a redelivery.
+type Envelope struct {
+	ID        string    `json:"id"`
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// UserData is how a user appears in payloads
+type UserData struct {
+	ID                 string  `json:"id"`
+	Name               string  `json:"name"`
+	Email              string  `json:"email"`
+	Role               string  `json:"role"`
+	Group              string  `json:"group,omitempty"`
+	Instructor         string  `json:"instructor,omitempty"`
+	RequiredDayHours   float64 `json:"required_day_hours"`
+	RequiredNightHours float64 `json:"required_night_hours"`
+}
+
+func userData(u *models.User) UserData {
+	return UserData{
+		ID:                 u.ID,
+		Name:               u.Name,
+		Email:              u.Email,
+		Role:               string(u.Role),
+		Group:              u.Group,
+		Instructor:         u.Instructor,
+		RequiredDayHours:   u.RequiredDayHours,
+		RequiredNightHours: u.RequiredNightHours,
+	}
+}
+
+// EntryData is the payload of the entry events. Previous is set for updates
+// and deletions.
+type EntryData struct {
+	User       UserData         `json:"user"`
+	Date       string           `json:"date"`
+	DayHours   float64          `json:"day_hours"`
+	NightHours float64          `json:"night_hours"`
+	Previous   *models.DayEntry `json:"previous,omitempty"`
+}
+
+// RequirementData is the payload of requirement.completed
+type RequirementData struct {
+	User            UserData `json:"user"`
+	TotalDayHours   float64  `json:"total_day_hours"`
+	TotalNightHours float64  `json:"total_night_hours"`
+}
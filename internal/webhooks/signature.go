@@ -0,0 +1,62 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers sent with every delivery
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// MaxSignatureAge is how old a delivery's timestamp may be before Verify
+// rejects it as a possible replay
+const MaxSignatureAge = 5 * time.Minute
+
+// NewSecret generates a random signing secret
+func NewSecret() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return "whsec_" + hex.EncodeToString(b)
+}
+
+// Sign returns the signature header value for body sent at timestamp (Unix
+// seconds): "sha256=" and the hex HMAC-SHA256 of "<timestamp>.<body>".
+// Including the timestamp stops an old delivery being replayed.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the timestamp and signature headers of a delivery against
+// body, as a receiver would
+func Verify(secret, timestampHeader, signatureHeader string, body []byte, now time.Time) error {
+	timestamp, err := strconv.ParseInt(timestampHeader, 10, 64)
+	if err != nil {
+		return errors.New("missing or invalid timestamp")
+	}
+	age := now.Sub(time.Unix(timestamp, 0))
+	if age > MaxSignatureAge || age < -MaxSignatureAge {
+		return errors.New("timestamp is too old or in the future")
+	}
+	if !strings.HasPrefix(signatureHeader, "sha256=") {
+		return errors.New("missing or invalid signature")
+	}
+	if !hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signatureHeader)) {
+		return errors.New("signature does not match")
+	}
+	return nil
+}
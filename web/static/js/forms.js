@@ -10,6 +10,22 @@ document.addEventListener('DOMContentLoaded', function() {
         }, 5000);
     });
 
+    // Ask before destructive actions: data-confirm on a button or a form
+    document.querySelectorAll('button[data-confirm]').forEach(button => {
+        button.addEventListener('click', function(e) {
+            if (!confirm(this.dataset.confirm)) {
+                e.preventDefault();
+            }
+        });
+    });
+    document.querySelectorAll('form[data-confirm]').forEach(form => {
+        form.addEventListener('submit', function(e) {
+            if (!confirm(this.dataset.confirm)) {
+                e.preventDefault();
+            }
+        });
+    });
+
     // Prevent double form submission
     const forms = document.querySelectorAll('form');
     forms.forEach(form => {
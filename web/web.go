@@ -0,0 +1,23 @@
+// Package web holds the HTML templates and static files, embedded into the
+// binary so it runs from any directory.
+package web
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed templates static
+var files embed.FS
+
+// Templates returns the embedded templates directory
+func Templates() fs.FS {
+	sub, _ := fs.Sub(files, "templates")
+	return sub
+}
+
+// Static returns the embedded static files directory
+func Static() fs.FS {
+	sub, _ := fs.Sub(files, "static")
+	return sub
+}